@@ -0,0 +1,76 @@
+/*
+   Copyright 2014 Hariharan Srinath
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"image"
+	"image/draw"
+	"image/gif"
+	"os"
+)
+
+//Animation holds the frames of an existing animated GIF that is being re-timed or edited
+type Animation struct {
+	Frames    []image.Image
+	Delays    []int
+	LoopCount int
+}
+
+//DecodeAnimation reads every frame of an animated GIF. GIF frames can cover just the part of
+//the screen that changed so each frame is drawn over the ones before it, following their
+//disposal methods, to give full images the size of the GIF
+func DecodeAnimation(filename string) (*Animation, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	g, err := gif.DecodeAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	for _, frame := range g.Image {
+		bounds = bounds.Union(frame.Bounds())
+	}
+	canvas := image.NewNRGBA(bounds)
+	anim := &Animation{Delays: g.Delay, LoopCount: g.LoopCount}
+	for j, frame := range g.Image {
+		var disposal byte
+		if j < len(g.Disposal) {
+			disposal = g.Disposal[j]
+		}
+		var previous *image.NRGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewNRGBA(bounds)
+			copy(previous.Pix, canvas.Pix)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		full := image.NewNRGBA(bounds)
+		copy(full.Pix, canvas.Pix)
+		anim.Frames = append(anim.Frames, full)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return anim, nil
+}
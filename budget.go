@@ -0,0 +1,158 @@
+/*
+   Copyright 2014 Hariharan Srinath
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+
+	"github.com/disintegration/imaging"
+)
+
+//UniformPalette returns a palette spreading levels values evenly over each of the red,
+//green & blue channels giving levels^3 colours
+func UniformPalette(levels int) color.Palette {
+	var pal color.Palette
+	for r := 0; r < levels; r++ {
+		for g := 0; g < levels; g++ {
+			for b := 0; b < levels; b++ {
+				pal = append(pal, color.RGBA{
+					R: uint8(r * 255 / (levels - 1)),
+					G: uint8(g * 255 / (levels - 1)),
+					B: uint8(b * 255 / (levels - 1)),
+					A: 0xff,
+				})
+			}
+		}
+	}
+	return pal
+}
+
+//budgetStep is one attempt at shrinking the GIF to fit -maxbytes. Frames are scaled by
+//scale & requantized to a uniform palette with levels per channel. A levels of 0 keeps the
+//frames' palette
+type budgetStep struct {
+	scale  float64
+	levels int
+}
+
+//budgetSteps are tried in order, first cutting colours since that costs less detail and
+//then the frame size. The list is fixed so results are repeatable
+var budgetSteps = []budgetStep{
+	{1, 0}, {1, 5}, {1, 4},
+	{0.85, 4}, {0.7, 4}, {0.55, 4}, {0.4, 4},
+	{0.4, 3}, {0.3, 3}, {0.25, 2},
+}
+
+//ReduceFrame remakes frame from img, the image it was quantized from, scaled by scale &
+//quantized to pal using drawer. A nil palette keeps the frame's own palette. Starting from
+//img rather than the frame means already dithered pixels aren't dithered again
+func ReduceFrame(scale float64, pal color.Palette, drawer draw.Drawer, frame *image.Paletted, img image.Image) *image.Paletted {
+	if scale == 1 && pal == nil {
+		return frame
+	}
+	if pal == nil {
+		pal = frame.Palette
+	}
+	if img == nil {
+		img = frame
+	}
+	if scale != 1 {
+		w := int(float64(img.Bounds().Dx())*scale + 0.5)
+		h := int(float64(img.Bounds().Dy())*scale + 0.5)
+		if w < 1 {
+			w = 1
+		}
+		if h < 1 {
+			h = 1
+		}
+		img = imaging.Resize(img, w, h, imaging.Lanczos)
+	}
+	return QuantizeImage(pal, drawer, img)
+}
+
+//transparentColor returns the first fully transparent colour in the palettes of frames so
+//a replacement palette can keep it, or nil if there is none
+func transparentColor(frames []*image.Paletted) color.Color {
+	for _, frame := range frames {
+		for _, c := range frame.Palette {
+			if _, _, _, a := c.RGBA(); a == 0 {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+//EncodeWithinBudget encodes the frames as a GIF no bigger than maxbytes, shrinking the
+//colours & then the size of the frames step by step as needed. Each step remakes the frames
+//from imgs, the images they were quantized from. With fixedpal the palette asked for is kept
+//& only the size shrinks. It returns the encoded GIF or an error if even the smallest step
+//does not fit. globaltable is passed on to EncodeGIF
+func EncodeWithinBudget(maxbytes int, drawer draw.Drawer, frames []*image.Paletted, imgs []image.Image, fixedpal bool, delays []int, loopcount int, globaltable bool, verbose bool) ([]byte, error) {
+	var buf bytes.Buffer
+	transparent := transparentColor(frames)
+	tried := make(map[budgetStep]bool)
+	overridden := false
+	for _, step := range budgetSteps {
+		if fixedpal {
+			step.levels = 0
+		}
+		if tried[step] {
+			continue
+		}
+		tried[step] = true
+
+		var pal color.Palette
+		if step.levels > 0 {
+			pal = UniformPalette(step.levels)
+			if transparent != nil {
+				pal = append(pal, transparent)
+			}
+			if !overridden {
+				log.Printf("Replacing the palette of the frames with fewer colours to fit in %d bytes", maxbytes)
+				overridden = true
+			}
+		}
+		reduced := make([]*image.Paletted, len(frames))
+		for j, frame := range frames {
+			reduced[j] = ReduceFrame(step.scale, pal, drawer, frame, imgs[j])
+		}
+
+		buf.Reset()
+		if err := EncodeGIF(&buf, reduced, delays, loopcount, globaltable); err != nil {
+			return nil, err
+		}
+
+		colours := "original"
+		if step.levels > 0 {
+			colours = fmt.Sprintf("%d", len(pal))
+		}
+		if buf.Len() <= maxbytes {
+			log.Printf("GIF fits in %d bytes at scale %g with %s colours (%d bytes)", maxbytes, step.scale, colours, buf.Len())
+			return buf.Bytes(), nil
+		}
+		if verbose {
+			log.Printf("GIF is %d bytes at scale %g with %s colours, over the %d byte limit", buf.Len(), step.scale, colours, maxbytes)
+		}
+	}
+	return nil, fmt.Errorf("could not fit the GIF in %d bytes, the smallest attempt was %d bytes", maxbytes, buf.Len())
+}
@@ -0,0 +1,104 @@
+/*
+   Copyright 2014 Hariharan Srinath
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+//bayer8 is the 8x8 Bayer threshold matrix with values 0-63
+var bayer8 = [8][8]int{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+//BayerDither is a draw.Drawer doing ordered dithering with an 8x8 Bayer matrix when drawing
+//onto a paletted image. It gives a regular cross-hatch pattern rather than the noise of
+//error diffusion. Other destination types are drawn without dithering
+type BayerDither struct{}
+
+//Draw implements draw.Drawer
+func (BayerDither) Draw(dst draw.Image, r image.Rectangle, src image.Image, sp image.Point) {
+	pdst, ok := dst.(*image.Paletted)
+	if !ok || len(pdst.Palette) == 0 {
+		draw.Draw(dst, r, src, sp, draw.Src)
+		return
+	}
+
+	//Spread the threshold over roughly the gap between neighbouring palette levels which for
+	//a palette of n colours evenly filling the colour cube is 255 / cuberoot(n)
+	spread := 255 / math.Cbrt(float64(len(pdst.Palette)))
+
+	r = r.Intersect(dst.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(src.At(sp.X+x-r.Min.X, sp.Y+y-r.Min.Y)).(color.NRGBA64)
+			offset := (float64(bayer8[y&7][x&7])+0.5)/64 - 0.5
+			adjust := func(v uint16) uint8 {
+				d := float64(v>>8) + offset*spread
+				if d < 0 {
+					return 0
+				}
+				if d > 255 {
+					return 255
+				}
+				return uint8(d)
+			}
+			dc := color.NRGBA{R: adjust(c.R), G: adjust(c.G), B: adjust(c.B), A: uint8(c.A >> 8)}
+			pdst.SetColorIndex(x, y, uint8(pdst.Palette.Index(dc)))
+		}
+	}
+}
+
+//Dithering returns the drawer for a -dither flag value. Valid values are floydsteinberg,
+//bayer & none. It returns nil for unknown values
+func Dithering(name string) draw.Drawer {
+	switch name {
+	case "floydsteinberg":
+		return draw.FloydSteinberg
+	case "bayer":
+		return BayerDither{}
+	case "none":
+		return draw.Src
+	}
+	return nil
+}
+
+//CountColors returns the number of distinct colours in img, stopping once limit is reached
+//since callers only need to know whether a frame is simple or not
+func CountColors(img image.Image, limit int) int {
+	seen := make(map[color.NRGBA]struct{})
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			seen[color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)] = struct{}{}
+			if len(seen) >= limit {
+				return len(seen)
+			}
+		}
+	}
+	return len(seen)
+}
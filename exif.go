@@ -0,0 +1,194 @@
+/*
+   Copyright 2014 Hariharan Srinath
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+//Capture tools can embed per image transform hints in the comments of a JPEG which
+//-exiftransforms applies in place of the crop & rotate flags. A hint is written as
+//"goanigiffy:crop=10,20,300x200;rotate=90" in the EXIF UserComment or ImageDescription or in
+//a JPEG comment. crop takes the same forms as -crop & rotate the same values as -rotate
+
+//hintPrefix marks a comment as holding transform hints
+const hintPrefix = "goanigiffy:"
+
+//TransformHints are the crop & rotation embedded in an image. Only the fields with their
+//Has flag set were given
+type TransformHints struct {
+	HasCrop                  bool
+	Left, Top, Width, Height int
+	HasRotate                bool
+	Rotate                   int
+}
+
+//ParseTransformHints parses hints like "crop=10,20,300x200;rotate=90" following hintPrefix
+func ParseTransformHints(spec string) (TransformHints, error) {
+	var hints TransformHints
+	for _, field := range strings.Split(spec, ";") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return TransformHints{}, fmt.Errorf("hint %q is not of the form key=value", field)
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		switch key {
+		case "crop":
+			var err error
+			if hints.Left, hints.Top, hints.Width, hints.Height, err = ParseCrop(value, 0); err != nil {
+				return TransformHints{}, err
+			}
+			hints.HasCrop = true
+		case "rotate":
+			rotate, ok := rotateNames[value]
+			if !ok {
+				return TransformHints{}, fmt.Errorf("invalid rotate hint %q", value)
+			}
+			hints.Rotate, hints.HasRotate = rotate, true
+		default:
+			return TransformHints{}, fmt.Errorf("unknown hint %q", key)
+		}
+	}
+	return hints, nil
+}
+
+//ReadTransformHints looks for transform hints in the comments of a JPEG. ok is false if the
+//file holds no hints, including when it isn't a JPEG at all
+func ReadTransformHints(filename string) (hints TransformHints, ok bool, err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return TransformHints{}, false, err
+	}
+	for _, comment := range jpegComments(data) {
+		comment = strings.TrimSpace(comment)
+		if !strings.HasPrefix(comment, hintPrefix) {
+			continue
+		}
+		hints, err = ParseTransformHints(strings.TrimPrefix(comment, hintPrefix))
+		return hints, err == nil, err
+	}
+	return TransformHints{}, false, nil
+}
+
+//jpegComments returns the text of the comment segments, EXIF UserComment & EXIF
+//ImageDescription found in the header of JPEG data. Anything which can't be parsed is
+//skipped
+func jpegComments(data []byte) []string {
+	var comments []string
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil
+	}
+	for n := 2; n+4 <= len(data) && data[n] == 0xff; {
+		marker := data[n+1]
+		//image data follows the start of scan so there are no more headers
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[n+2:]))
+		if length < 2 || n+2+length > len(data) {
+			break
+		}
+		segment := data[n+4 : n+2+length]
+		switch {
+		case marker == 0xfe:
+			comments = append(comments, string(segment))
+		case marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")):
+			if exif, err := exifComments(segment[6:]); err == nil {
+				comments = append(comments, exif...)
+			}
+		}
+		n += 2 + length
+	}
+	return comments
+}
+
+//EXIF tags holding text that transform hints may be written in
+const (
+	tagImageDescription = 0x010e
+	tagExifIFD          = 0x8769
+	tagUserComment      = 0x9286
+)
+
+//exifComments returns the ImageDescription & UserComment from EXIF data in TIFF layout
+func exifComments(tiff []byte) ([]string, error) {
+	if len(tiff) < 8 {
+		return nil, errors.New("truncated EXIF")
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("invalid EXIF byte order")
+	}
+
+	//Offsets & sizes are kept as uint64 until they are checked against the data so crafted
+	//values can't wrap around to negative ints on 32 bit platforms
+	var comments []string
+	length := uint64(len(tiff))
+	ifd := uint64(order.Uint32(tiff[4:]))
+	for visited := 0; ifd != 0 && visited < 2; visited++ {
+		if ifd+2 > length {
+			return nil, errors.New("truncated EXIF directory")
+		}
+		count := uint64(order.Uint16(tiff[ifd:]))
+		var next uint64
+		for j := uint64(0); j < count; j++ {
+			entry := ifd + 2 + 12*j
+			if entry+12 > length {
+				return nil, errors.New("truncated EXIF directory")
+			}
+			tag := order.Uint16(tiff[entry:])
+			size := uint64(order.Uint32(tiff[entry+4:]))
+			value := tiff[entry+8 : entry+12]
+			if size > 4 {
+				offset := uint64(order.Uint32(value))
+				if offset+size > length {
+					continue
+				}
+				value = tiff[offset : offset+size]
+			} else {
+				value = value[:size]
+			}
+			switch tag {
+			case tagImageDescription:
+				comments = append(comments, strings.TrimRight(string(value), "\x00"))
+			case tagUserComment:
+				//UserComment starts with 8 bytes naming its character code. Only ASCII
+				//& undefined codes are read
+				if len(value) > 8 && (bytes.HasPrefix(value, []byte("ASCII")) || value[0] == 0) {
+					comments = append(comments, strings.TrimRight(string(value[8:]), "\x00 "))
+				}
+			case tagExifIFD:
+				next = uint64(order.Uint32(tiff[entry+8:]))
+			}
+		}
+		ifd = next
+	}
+	return comments, nil
+}
@@ -0,0 +1,89 @@
+/*
+   Copyright 2014 Hariharan Srinath
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"log"
+)
+
+//SubjectDetector finds the main subject of an image such as a face
+type SubjectDetector interface {
+	//Detect returns the bounds of the subject & whether one was found
+	Detect(img image.Image) (image.Rectangle, bool)
+	Close() error
+}
+
+//newSubjectDetector loads a detector from a Haar cascade file. It is only set when
+//goanigiffy is built with the gocv tag since the detector needs OpenCV
+var newSubjectDetector func(cascade string) (SubjectDetector, error)
+
+//NewSubjectDetector loads a detector from a Haar cascade file or explains how to get one if
+//goanigiffy was built without detection
+func NewSubjectDetector(cascade string) (SubjectDetector, error) {
+	if newSubjectDetector == nil {
+		return nil, fmt.Errorf("subject detection needs goanigiffy built with OpenCV via go build -tags gocv")
+	}
+	return newSubjectDetector(cascade)
+}
+
+//FollowPath works out a crop path which keeps the subject found by detector centered in a
+//width x height crop of each image, with the crop kept inside the image. open reads image j
+//of filenames, which lets the frames of an animated GIF be followed too. Images where no
+//subject is found, or which can't be read, keep the offset of the image before while those
+//before the first subject found use its offset. It is an error if no subject is found at all
+//or the crop is bigger than an image
+func FollowPath(detector SubjectDetector, filenames []string, open func(j int) (image.Image, error), width, height int, verbose bool) ([]image.Point, error) {
+	path := make([]image.Point, len(filenames))
+	found := -1
+	for j, filename := range filenames {
+		if found >= 0 {
+			path[j] = path[j-1]
+		}
+		img, err := open(j)
+		if err != nil {
+			continue
+		}
+		subject, ok := detector.Detect(img)
+		if !ok {
+			if verbose {
+				log.Printf("No subject found in %s", filename)
+			}
+			continue
+		}
+		b := img.Bounds()
+		if width > b.Dx() || height > b.Dy() {
+			return nil, fmt.Errorf("the %dx%d crop is bigger than the %dx%d image %s", width, height, b.Dx(), b.Dy(), filename)
+		}
+		center := subject.Min.Add(subject.Max).Div(2).Sub(b.Min)
+		path[j] = image.Pt(clampInt(center.X-width/2, 0, b.Dx()-width), clampInt(center.Y-height/2, 0, b.Dy()-height))
+		if verbose {
+			log.Printf("Following subject at (%d,%d)->(%d,%d) in %s", subject.Min.X, subject.Min.Y, subject.Max.X, subject.Max.Y, filename)
+		}
+		if found < 0 {
+			for k := 0; k < j; k++ {
+				path[k] = path[j]
+			}
+			found = j
+		}
+	}
+	if found < 0 {
+		return nil, fmt.Errorf("no subject found in any of the %d images", len(filenames))
+	}
+	return path, nil
+}
@@ -0,0 +1,67 @@
+//go:build gocv
+// +build gocv
+
+/*
+   Copyright 2014 Hariharan Srinath
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+func init() {
+	newSubjectDetector = newCascadeDetector
+}
+
+//cascadeDetector finds subjects with an OpenCV Haar cascade classifier
+type cascadeDetector struct {
+	classifier gocv.CascadeClassifier
+}
+
+//newCascadeDetector loads the Haar cascade XML file cascade, eg. one of the face cascades
+//that come with OpenCV
+func newCascadeDetector(cascade string) (SubjectDetector, error) {
+	classifier := gocv.NewCascadeClassifier()
+	if !classifier.Load(cascade) {
+		classifier.Close()
+		return nil, fmt.Errorf("could not load the cascade %s", cascade)
+	}
+	return &cascadeDetector{classifier}, nil
+}
+
+//Detect returns the largest subject the cascade finds in img
+func (d *cascadeDetector) Detect(img image.Image) (image.Rectangle, bool) {
+	mat, err := gocv.ImageToMatRGB(img)
+	if err != nil {
+		return image.Rectangle{}, false
+	}
+	defer mat.Close()
+	var largest image.Rectangle
+	for _, r := range d.classifier.DetectMultiScale(mat) {
+		if r.Dx()*r.Dy() > largest.Dx()*largest.Dy() {
+			largest = r
+		}
+	}
+	return largest.Add(img.Bounds().Min), !largest.Empty()
+}
+
+func (d *cascadeDetector) Close() error {
+	return d.classifier.Close()
+}
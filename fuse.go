@@ -0,0 +1,144 @@
+/*
+   Copyright 2014 Hariharan Srinath
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+//exposureSigma is how quickly the well exposedness weight falls off as a value moves away
+//from mid gray, on a 0-1 scale
+const exposureSigma = 0.2
+
+//fusionWeights returns the exposure fusion weight of each pixel of img. Pixels score highly
+//where there is local contrast, strong colour & values close to mid gray
+func fusionWeights(img *image.NRGBA) []float64 {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	gray := make([]float64, w*h)
+	weights := make([]float64, w*h)
+	for i := range gray {
+		p := img.Pix[i*4 : i*4+3]
+		r, g, b := float64(p[0])/255, float64(p[1])/255, float64(p[2])/255
+		gray[i] = 0.299*r + 0.587*g + 0.114*b
+
+		mean := (r + g + b) / 3
+		saturation := math.Sqrt(((r-mean)*(r-mean) + (g-mean)*(g-mean) + (b-mean)*(b-mean)) / 3)
+		exposedness := 1.0
+		for _, v := range []float64{r, g, b} {
+			exposedness *= math.Exp(-(v - 0.5) * (v - 0.5) / (2 * exposureSigma * exposureSigma))
+		}
+		weights[i] = saturation * exposedness
+	}
+	//Contrast is the size of the laplacian with edge pixels repeated beyond the border
+	at := func(x, y int) float64 {
+		x = clampInt(x, 0, w-1)
+		y = clampInt(y, 0, h-1)
+		return gray[y*w+x]
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			contrast := math.Abs(at(x-1, y) + at(x+1, y) + at(x, y-1) + at(x, y+1) - 4*at(x, y))
+			weights[y*w+x] = (contrast+0.01)*(weights[y*w+x]+0.01) + 1e-6
+		}
+	}
+	return weights
+}
+
+//boxBlur smooths the w x h values in place with a box of the given radius, first along the
+//rows & then down the columns
+func boxBlur(values []float64, w, h, radius int) {
+	line := make([]float64, 0, w+h)
+	blurLine := func(get func(int) float64, set func(int, float64), n int) {
+		line = line[:0]
+		for j := 0; j < n; j++ {
+			line = append(line, get(j))
+		}
+		sum := 0.0
+		for j := -radius; j <= radius; j++ {
+			sum += line[clampInt(j, 0, n-1)]
+		}
+		for j := 0; j < n; j++ {
+			set(j, sum/float64(2*radius+1))
+			sum += line[clampInt(j+radius+1, 0, n-1)] - line[clampInt(j-radius, 0, n-1)]
+		}
+	}
+	for y := 0; y < h; y++ {
+		blurLine(func(x int) float64 { return values[y*w+x] }, func(x int, v float64) { values[y*w+x] = v }, w)
+	}
+	for x := 0; x < w; x++ {
+		blurLine(func(y int) float64 { return values[y*w+x] }, func(y int, v float64) { values[y*w+x] = v }, h)
+	}
+}
+
+//FuseExposures merges a set of bracketed exposures into a single image with exposure fusion.
+//Every pixel is a weighted average of the frames which favours the frames where that part
+//of the scene shows detail, colour & is neither too dark nor blown out. The weights are
+//smoothed so the blend doesn't change abruptly between neighbouring pixels. Frames of a
+//different size to the first are left out
+func FuseExposures(frames []image.Image, verbose bool) (*image.NRGBA, error) {
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("no frames to fuse")
+	}
+	bounds := frames[0].Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 {
+		return nil, fmt.Errorf("frames are empty")
+	}
+	radius := w
+	if h < radius {
+		radius = h
+	}
+	radius = radius/64 + 1
+
+	var srcs []*image.NRGBA
+	var weights [][]float64
+	for j, frame := range frames {
+		if !frame.Bounds().Eq(bounds) {
+			log.Printf("Leaving frame %d out of the fusion since it is a different size to the first", j)
+			continue
+		}
+		src := imaging.Clone(frame)
+		weight := fusionWeights(src)
+		boxBlur(weight, w, h, radius)
+		srcs = append(srcs, src)
+		weights = append(weights, weight)
+	}
+	if verbose {
+		log.Printf("Fusing %d exposures of %dx%d", len(srcs), w, h)
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < w*h; i++ {
+		total := 0.0
+		var sum [4]float64
+		for k, src := range srcs {
+			for c := 0; c < 4; c++ {
+				sum[c] += weights[k][i] * float64(src.Pix[i*4+c])
+			}
+			total += weights[k][i]
+		}
+		for c := 0; c < 4; c++ {
+			dst.Pix[i*4+c] = uint8(math.Min(255, sum[c]/total+0.5))
+		}
+	}
+	return dst, nil
+}
@@ -0,0 +1,254 @@
+/*
+   Copyright 2014 Hariharan Srinath
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"compress/lzw"
+	"errors"
+	"fmt"
+	"image/gif"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//image/gif doesn't expose every part of the GIF format so this file holds helpers which
+//patch the bytes it encodes
+
+//gifHeaderLen returns the length of the GIF header, logical screen descriptor & global
+//colour table at the start of an encoded GIF. The blocks for the frames follow these
+func gifHeaderLen(data []byte) (int, error) {
+	const lsd = 6 + 7
+	if len(data) < lsd || string(data[:3]) != "GIF" {
+		return 0, errors.New("not a GIF")
+	}
+	n := lsd
+	if flags := data[10]; flags&0x80 != 0 {
+		n += 3 * (1 << (uint(flags&0x07) + 1))
+	}
+	if len(data) < n {
+		return 0, errors.New("truncated GIF header")
+	}
+	return n, nil
+}
+
+//commentBlock returns a GIF comment extension block holding text
+func commentBlock(text string) []byte {
+	return appendSubBlocks([]byte{0x21, 0xfe}, []byte(text))
+}
+
+//AddComments inserts a comment extension for each non-empty comment into an encoded GIF
+//ahead of its frames
+func AddComments(data []byte, comments ...string) ([]byte, error) {
+	n, err := gifHeaderLen(data)
+	if err != nil {
+		return nil, err
+	}
+	var blocks []byte
+	for _, comment := range comments {
+		if comment != "" {
+			blocks = append(blocks, commentBlock(comment)...)
+		}
+	}
+	if len(blocks) == 0 {
+		return data, nil
+	}
+	out := make([]byte, 0, len(data)+len(blocks))
+	out = append(out, data[:n]...)
+	out = append(out, blocks...)
+	return append(out, data[n:]...), nil
+}
+
+//subBlocks returns the data of the chain of sub-blocks starting at data[n] along with the
+//index just past its terminating empty block
+func subBlocks(data []byte, n int) ([]byte, int, error) {
+	var out []byte
+	for {
+		if n >= len(data) {
+			return nil, 0, errors.New("truncated GIF block")
+		}
+		size := int(data[n])
+		n++
+		if size == 0 {
+			return out, n, nil
+		}
+		if n+size > len(data) {
+			return nil, 0, errors.New("truncated GIF block")
+		}
+		out = append(out, data[n:n+size]...)
+		n += size
+	}
+}
+
+//appendSubBlocks appends b to out as a chain of sub-blocks of at most 255 bytes
+func appendSubBlocks(out, b []byte) []byte {
+	for len(b) > 0 {
+		n := len(b)
+		if n > 255 {
+			n = 255
+		}
+		out = append(out, byte(n))
+		out = append(out, b[:n]...)
+		b = b[n:]
+	}
+	return append(out, 0x00)
+}
+
+//interlaceRows returns the order rows of an image of the given height are stored in an
+//interlaced GIF: every 8th row from 0, every 8th from 4, every 4th from 2 & every 2nd from 1
+func interlaceRows(height int) []int {
+	rows := make([]int, 0, height)
+	for _, pass := range []struct{ start, step int }{{0, 8}, {4, 8}, {2, 4}, {1, 2}} {
+		for y := pass.start; y < height; y += pass.step {
+			rows = append(rows, y)
+		}
+	}
+	return rows
+}
+
+//InterlaceGIF rewrites every frame of an encoded GIF to be interlaced so viewers can show
+//a rough version of each frame while it is still loading. image/gif can't write
+//interlaced frames so each frame's pixels are decompressed, reordered & compressed again
+func InterlaceGIF(data []byte) ([]byte, error) {
+	n, err := gifHeaderLen(data)
+	if err != nil {
+		return nil, err
+	}
+	out := append([]byte{}, data[:n]...)
+	for n < len(data) {
+		switch data[n] {
+		case 0x3b:
+			return append(out, data[n:]...), nil
+		case 0x21:
+			if n+2 > len(data) {
+				return nil, errors.New("truncated GIF extension")
+			}
+			_, end, err := subBlocks(data, n+2)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, data[n:end]...)
+			n = end
+		case 0x2c:
+			if n+11 > len(data) {
+				return nil, errors.New("truncated GIF image descriptor")
+			}
+			desc := append([]byte{}, data[n:n+10]...)
+			width := int(desc[5]) | int(desc[6])<<8
+			height := int(desc[7]) | int(desc[8])<<8
+			start := n + 10
+			if desc[9]&0x80 != 0 {
+				start += 3 * (1 << (uint(desc[9]&0x07) + 1))
+			}
+			if start >= len(data) {
+				return nil, errors.New("truncated GIF colour table")
+			}
+			if desc[9]&0x40 != 0 {
+				//already interlaced
+				_, end, err := subBlocks(data, start+1)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, data[n:end]...)
+				n = end
+				continue
+			}
+			litwidth := int(data[start])
+			compressed, end, err := subBlocks(data, start+1)
+			if err != nil {
+				return nil, err
+			}
+			r := lzw.NewReader(bytes.NewReader(compressed), lzw.LSB, litwidth)
+			pix, err := ioutil.ReadAll(r)
+			r.Close()
+			if err != nil {
+				return nil, err
+			}
+			if len(pix) < width*height {
+				return nil, errors.New("not enough pixels in GIF frame")
+			}
+			var buf bytes.Buffer
+			w := lzw.NewWriter(&buf, lzw.LSB, litwidth)
+			for _, y := range interlaceRows(height) {
+				if _, err := w.Write(pix[y*width : (y+1)*width]); err != nil {
+					return nil, err
+				}
+			}
+			if err := w.Close(); err != nil {
+				return nil, err
+			}
+			desc[9] |= 0x40
+			out = append(out, desc...)
+			out = append(out, data[n+10:start+1]...)
+			out = appendSubBlocks(out, buf.Bytes())
+			n = end
+		default:
+			return nil, fmt.Errorf("unknown GIF block 0x%02x", data[n])
+		}
+	}
+	return nil, errors.New("GIF has no trailer")
+}
+
+//VerifyGIF re-reads & fully decodes a written GIF to check it holds the expected number of
+//frames. This catches truncated writes such as when the disk is full
+func VerifyGIF(filename string, frames int) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	g, err := gif.DecodeAll(f)
+	if err != nil {
+		return err
+	}
+	if len(g.Image) != frames {
+		return fmt.Errorf("found %d frames instead of %d", len(g.Image), frames)
+	}
+	return nil
+}
+
+//WriteFileAtomic writes data to filename through a temporary file in the same directory
+//which is renamed over filename once it is complete. Readers & interrupted runs see either
+//the old file or the whole new one, never a partial file. An existing file keeps its mode
+func WriteFileAtomic(filename string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -19,27 +19,465 @@ GoAniGiffy is a utility for converting a set of alphabetically sorted images suc
 grabbed from VLC or MPlayer into an animated GIF with options to Crop, Resize, Rotate & Flip the
 images prior to creating the GIF
 
-GoAniGiffy performs image operations in the order of cropping, scaling, rotating & flipping
-before converting the images into an Animated GIF. Image manipulation is done using
-Grigory Dryapak's imaging package. We use the Lanczos filter in Resizing and the default
+GoAniGiffy performs image operations in the order of trimming, cropping, scaling, rotating &
+flipping followed by any effects like pixelation before converting the images into an
+Animated GIF. The -order parameter can change the order of the crop, scale, rotate & flip
+operations, eg. -order=rotate,crop crops in rotated co-ordinates. Operations left out of
+-order follow in their usual order. An image is skipped with an error if the crop doesn't fit
+it at the point the crop runs, eg. after a rotate swaps its width & height. Image
+manipulation is done using Grigory Dryapak's imaging package. We use the Lanczos filter in Resizing and by default the
 Floyd-Steinberg dithering used by Go Language's image/gif package to ensure video quality.
-Arbitrary angle rotations are not supported.
+The -dither parameter can instead select ordered Bayer dithering for a retro look or none.
+With -smartdither, frames with no more than -smartditherthreshold colours are not dithered
+which keeps solid colours crisp in screen captures.
+The -rotate parameter turns images by multiples of 90 degrees while -spin & -rotateauto
+rotate by any angle.
 
 The -delay parameter must be an integer specifying delay between frames in hundredths of
-a second. A value of 3 would give approximately 33 fps theoritically
+a second. A value of 3 would give approximately 33 fps theoritically. It can also be a comma
+separated list of delays like 100,3,3,3,100 which are given to the frames in turn & repeated
+as needed, eg. to linger on the first & last frames.
+
+Browsers play frames with a delay below 2 with a delay of 10 instead, so a GIF with -delay=1
+plays far slower in a browser than intended. A message is logged when this would happen & the
+-minbrowserdelay parameter raises such delays to 2.
+
+The -loopdelay parameter adds a pause before the animation loops by lengthening the delay of
+just the last frame, eg. -loopdelay=200 holds the last frame for 2 more seconds. It is not
+changed by -speed.
+
+The -jitter parameter makes loops feel less mechanical by varying each frame's delay at random
+by up to the given percentage either way, eg. -jitter=20 turns a delay of 10 into anything
+from 8 to 12. The same -seed always gives the same delays while the default of 0 picks a new
+seed every run which -verbose reports. Jitter is applied after -speed & before -loopdelay.
+
+The -maxduration parameter keeps a GIF within the length limit of sites that have one by
+dropping the frames which would play past the given number of seconds. Unlike -speed, which
+plays every frame faster, it cuts the animation short & reports how many frames were dropped.
+The playing time counts each frame's delay after -speed & -jitter but not the -loopdelay pause.
+
+Pressing Ctrl-C while images are being parsed stops processing further images and writes
+out an animated GIF of the frames processed so far. Pressing Ctrl-C again exits immediately.
+
+The -autolevels parameter fixes flat, low contrast captures by stretching the darkest &
+brightest values to black & white. With "frame" each frame is stretched on its own while
+"global" uses a single stretch for all frames so brightness doesn't flicker.
+
+The -lut parameter colour grades each frame with a 3D LUT in the .cube format used by video
+editors, so footage can get the same look as in the edit. Colours between the points of the
+LUT are interpolated trilinearly.
+
+The -autowb parameter removes colour casts from mixed lighting with a gray world white
+balance which evens out the average red, green & blue of a frame. Like -autolevels, "frame"
+corrects each frame on its own while "global" uses one correction for all frames so colours
+don't shift. White balance is applied before -autolevels.
+
+The -accumulate parameter composites each frame with all the frames before it so that bright
+moving things leave trails like a long exposure. -accumulatemode "max" keeps the brightest
+value of each colour channel, "lighten" keeps whichever pixel is brighter overall and "add"
+sums the frames.
+
+The -motionblur parameter blends each frame with the frames before it with older frames
+fading out geometrically. -motionblurstrength sets the weight (0-1) given to the preceding
+frames.
+
+The -interpolate parameter smooths choppy, low frame rate sequences by inserting
+-interpolatefactor frames blended between each pair of frames. Each frame's delay is shared out
+over it & the frames that follow it so the animation takes the same time overall, though no
+delay is made shorter than 1.
+
+The -overlap parameter is a cheaper way to smooth choppy motion which ghosts a little of the
+next frame into each frame without adding frames, so the GIF stays about the same size.
+-overlapamount sets the weight (0-1) given to the next frame.
+
+The -palettefile parameter forces every frame to be dithered to the same fixed palette. The
+file holds hex colours either as plain text separated by spaces, commas or newlines or as a
+JSON array like ["#000000", "#ffffff", "#e4002b"]
+
+The -palette parameter picks a built in fixed palette for every frame instead of the default
+Plan 9 palette. "websafe" uses the 216 colours of the classic web safe palette for the most
+compatible colours & a retro web look.
+
+The -quality parameter sets the palette & dithering together from 1, which is fast & small, to
+10, which looks best. Levels 1 to 3 use a reduced palette of 27 or 64 colours, 4 & 5 the
+default Plan 9 palette with Bayer or Floyd-Steinberg dithering & 6 to 10 pick 64 up to 256
+colours to suit each frame with median cut. An explicit -dither or -palettefile still wins.
+
+The -adaptivecolors parameter makes GIFs of frames that are sometimes simple & sometimes
+busy smaller by giving each frame only the colours it needs. Frames with at most 256 colours
+get a palette of exactly their colours without dithering while busier frames get 256 colours
+picked with median cut, or the number of colours of -quality 6 to 10. A -palettefile wins.
+
+The -colortable parameter sets where the palettes of the frames are written. A global colour
+table is written once for the whole GIF which saves up to 768 bytes a frame but needs all
+frames to share one palette, so it can't be used with -adaptivecolors or -quality 6 to 10.
+Local colour tables are written with every frame & allow a palette suited to each frame. The
+default of auto writes a global table when the frames share a palette & local tables
+otherwise.
+
+The -stabilizepalette parameter stops the shimmer of GIFs whose frames each get their own
+palette, with -quality 6 to 10 or -adaptivecolors, when noise in otherwise similar frames
+shifts the colours picked slightly from frame to frame. Each colour within
+-stabilizetolerance on every channel of a colour of the frame before is replaced by it so the
+colours stay put while the scene stays the same. Frames given exactly their own colours by
+-adaptivecolors are left as they are. Fixed palettes & -colortable=global already give every
+frame the same colours so -stabilizepalette has no effect on them.
+
+The -dumppalette parameter writes the palette the frames were quantized to, which helps to
+explain colours that look off. A .png or .jpg file gets a swatch image while any other file
+gets a list of hex colours that can be reused with -palettefile. With -maxbytes the frames
+may use fewer colours than the palette written.
+
+The -docrop, -doscale, -dorotate & -doflip parameters switch the crop, scale, rotate & flip
+operations on or off whatever their other flags are set to, which is clearer for scripts
+than setting -cropwidth=-1, -scale=1, -rotate=0 or -flip=none, eg. -docrop=false keeps the
+crop flags on the command line but skips the crop.
+
+The -crop parameter is a shorter way to give the crop rectangle, either as the corners
+"left,top,right,bottom" or as "left,top,WxH". It cannot be combined with the individual
+crop flags. For scanned documents the -dpi of the images lets the crop be given in inches or
+millimetres, eg. -dpi=300 -crop=0.5in,0.5in,8inx6in or -dpi=300 -crop=10mm,10mm,200mmx150mm
+where plain numbers are still pixels.
+
+The -exiftransforms parameter applies crop & rotate hints that a capture tool embedded in
+each JPEG in place of the crop & rotate flags. The hints are read from the EXIF UserComment
+or ImageDescription or a JPEG comment written like goanigiffy:crop=10,20,300x200;rotate=90
+where crop takes the same forms as -crop & rotate the same values as -rotate. Images without
+hints use the flags & malformed hints are logged & ignored.
+
+Only the pixels of the source images are used so metadata like EXIF camera details & location
+never ends up in the GIF or in the -poster & -thumb images. The only metadata written is the
+GIF comment from -comment & -credit.
+
+The -cropaspect parameter crops the largest rectangle of an aspect ratio like "1:1" or "16:9"
+out of the center of each image, eg. to make square frames. It is applied after any explicit
+crop as part of the crop operation.
+
+The -match & -exclude parameters filter the files found by -src with regular expressions on
+the file name (without the directory) which helps when frames share a directory with other
+files of similar names, eg. -src="*.png" -match="^frame_[0-9]+" -exclude="_thumb".
+
+The -cropgrid parameter splits each image into a grid of equal tiles given as rows x columns
+like 4x8 & makes each tile a frame, going along each row in turn, which turns a sprite sheet
+into an animation. The tile size is the image size divided by the grid rounded down & any
+pixels left over at the right & bottom edges are dropped. All the other parameters apply to
+each tile as if it were an image of its own.
+
+The -croppath parameter pans the crop window to follow a moving subject. It names a file with
+a "left,top" crop offset for each image on its own line while -cropwidth & -cropheight set
+the fixed size of the window. Blank lines & images beyond the end of the file keep the last
+offset.
+
+The -cropfollow parameter pans the crop window automatically to keep a subject such as a face
+centered, eg. for talking head captures. It names an OpenCV Haar cascade file like
+haarcascade_frontalface_default.xml & -cropwidth & -cropheight set the size of the window.
+Images where no subject is found keep the window where it was. Detection needs OpenCV so
+goanigiffy has to be built with go build -tags gocv to use it. The subject is found in the
+images as they are read so crop has to be the first operation of -order & -standardize,
+-trim, -trimuniform & -exiftransforms can't be used with it.
+
+The -dest parameter can list several comma separated filenames, eg. -dest=movie.gif,backup.gif
+to write the same GIF to each without processing the frames again. Only GIFs can be written
+so names ending in other formats like .webp or .png are refused. The -mkdir parameter creates the
+directories a -dest is in if they don't exist yet, eg. -dest=out/2014-06-01/movie.gif.
+
+Since a GIF pixel can only be fully transparent or opaque, goanigiffy warns when images are
+partly transparent, eg. the soft edges of a cut out subject in a PNG sequence, as they are
+flattened onto the -background colour. Formats with full transparency like APNG or WebP keep
+soft edges.
+
+Each destination is written to a temporary file alongside it which then replaces it in one
+step, so an existing GIF is never left half written if goanigiffy is stopped & programs
+reading it see either the old GIF or the complete new one.
+
+The -reverseoutput parameter also writes the frames played backwards to a second GIF next to
+each destination with _reverse added to its name, eg. movie_reverse.gif for movie.gif. Any
+-loopdelay pause or -noloop hold stays on the last frame of the reversed GIF.
+
+The -hashmanifest parameter writes a file listing the SHA-256 hash of every source image &
+the settings used once the GIF is written. With -skipunchanged, a run whose source images &
+settings match the manifest from the last run does nothing, which speeds up incremental
+rebuilds in scripts. Only the source images are hashed so changes to files like -palettefile
+need a rebuild without -skipunchanged.
+
+The -saverecipe parameter saves the settings given for a GIF to a JSON recipe file like
+{"delay": "5", "scale": "0.5"} so that a known good set of settings can be shared & reused.
+-loadrecipe applies a saved recipe as though its settings were given on the command line,
+with any flags actually given on the command line taking precedence, eg.
+goanigiffy -loadrecipe=recipe.json -src="take2/*.jpg" -dest=take2.gif
+Flags naming the files of one run are left out of a recipe so reusing it never overwrites
+the last run's outputs. These are -src, -dest, -croppath, -timestamps, -poster, -thumb,
+-spritesheet, -htmlpreview, -hashmanifest, -dumppalette, -fuse, -timingfile & -timingcsv.
+
+The -htmlpreview parameter writes a web page showing the GIF along with its frame count, size,
+duration & the parameters that were set. The GIF is embedded in the page so it can be sent to
+reviewers on its own.
+
+The -spritesheet parameter also saves all the frames laid out in a single png or jpg image for
+CSS animation on the web, in one row or in a grid of -spritesheetcols columns. A .css file of
+the same name is written alongside with a .sprite class that steps through the frames with
+their delays.
+
+The -fuse parameter treats the images as bracketed exposures of the same scene & merges them
+into a single png or jpg still with exposure fusion in place of writing a GIF. Each part of the
+still comes mostly from the exposures showing detail & colour there without being too dark or
+blown out. The images are processed as usual first so crops & scales apply.
+
+The -noloop parameter makes the GIF play once & stop on its last frame rather than loop
+forever. GIFs have no true stop, and some viewers loop every GIF regardless, so the last frame
+is also held for at least 10 seconds.
+
+The -nosort parameter skips the alphabetical sort of source images. Note that a -src glob
+already lists the files within each directory alphabetically so -nosort is mostly useful to
+keep the order of image files given on the command line.
+
+The -parsetiming parameter reproduces the timing of irregularly sampled captures from times
+written in the file names, eg. frame_001523ms.jpg or shot-1.5s.png, with the last number
+followed by ms or s in the name taken as the time. Each frame is shown until the time of the
+next frame & the last frame as long as the one before it. If any name has no time or the
+times don't increase, -delay is used instead.
+
+The -autodelay parameter reproduces the original playback speed of frames grabbed from video,
+including variable frame rate video, from a -timestamps file listing the time of each image
+in milliseconds on its own line. ffmpeg writes such a file for the frames it extracts with
+eg. ffmpeg -i clip.mp4 -f mkvtimestamp_v2 times.txt. There must be one line for each image
+-src finds, matched in sorted order before -match & -exclude leave any out. Without -timestamps
+the times in the file names are used as for -parsetiming & if the times can't be read -delay
+is used instead.
+
+The -clip parameter selects a section of the images by time with a spec like
+"start=2s,end=6s,fps=15" where fps is the rate the frames were grabbed at. The GIF is played
+back at the same rate, replacing -delay. If fps is left out, it is worked out from -delay, or
+from the delays of an animated GIF -src.
+
+The -preset parameter is a convenient way to get a smaller GIF. The video style presets 240p,
+360p, 480p, 720p & 1080p shrink the frames to fit within a 16:9 box of that height while
+square-N (eg. square-500) letterboxes them onto an N x N canvas. Presets are applied after
+flipping & never enlarge the frames. The frames are centered on the square canvas unless
+-fitanchor gives another position such as bottom to keep the subject at the bottom or topleft.
+
+The -sidecartext parameter labels frames from a text file sitting alongside each image with
+the same name, eg. text in frame001.txt is drawn in the bottom left of the frame made from
+frame001.jpg. Images without a text file are left unlabelled.
+
+The -maxbytes parameter keeps the GIF within a size limit such as those of chat platforms. If
+the GIF is too big, it is reduced in a fixed series of steps, first to fewer colours & then to
+smaller frames, until it fits. Each step remakes the frames from the processed images so
+dithering doesn't build up. A palette chosen with -palette or -palettefile is kept & only the
+frames shrink, while any other palette is replaced with a note in the log. The settings used
+are reported & it is an error if even the smallest step doesn't fit.
+
+The -interlace parameter writes interlaced frames which viewers can draw progressively, first
+every 8th row & then filling in the rest, which helps on slow connections. Interlacing is done
+after -maxbytes & can change the size slightly.
+
+The -scale parameter takes either a factor like 0.5 or a percentage like 50%, both of which halve
+the size of the images. Note that -scale=50 makes images 50 times bigger.
+
+The -scalestart & -scaleend parameters zoom in or out by changing the scale smoothly from the
+first to the last image in place of -scale. The zoomed frames are cropped around the center to
+the size of the frames at the smaller of the two scales so the canvas stays the same size.
+
+The -spin parameter makes the subject appear to spin by rotating each frame a little more than
+the one before, adding up to the given number of degrees over the animation in the direction
+set by -spindirection. Frames keep their size & the exposed corners are filled with the
+-background colour. A spin of 360 loops smoothly.
+
+The -mirror parameter gives frames a kaleidoscope like symmetry by reflecting the left half
+of each frame onto the right half. -mirroraxis set to horizontal reflects the top half onto
+the bottom half instead, both does both & none turns mirroring off.
+
+The -denoise parameter smooths out sensor noise & grain with a median filter before the frames
+are converted to GIF colours. Grain changes every pixel of every frame so removing it usually
+makes the GIF smaller as well. -denoisestrength sets the radius of the filter in pixels.
+
+The -scanlines parameter darkens every other row of pixels for the look of an old CRT screen
+which goes well with -pixelate. -scanlineintensity sets how much (0-1) the rows are darkened.
+
+The -rotateauto parameter straightens handheld captures. It finds the angle of the strongest
+near horizontal edges in each frame, such as the horizon, & rotates the frame to level them.
+Only tilts of up to 15 degrees are corrected & the corners exposed are filled with the
+-background colour. -verbose logs the angle found for each frame.
+
+Rotating by -spin or -rotateauto normally keeps the size of the frames & clips the corners of
+the rotated image. The -rotateexpand parameter enlarges the canvas instead so the whole image
+is kept, filling the new corners with the -background colour. The canvas fits the largest
+angle used so all frames stay the same size, eg. a 400x300 image becomes up to 495x495 for a -spin
+of 90 or more & 465x394 with -rotateauto which allows for tilts of up to 15 degrees.
+
+The -standardize parameter fixes sources of mixed sizes. It reads just the size of each
+image, reports how many images there are of each size & then centers every image on a canvas
+of the most common size, padding smaller images with the -background colour & cropping
+larger ones.
+
+The -skipsolid parameter drops frames which are a single solid colour, such as the black frames
+at scene cuts in video frame dumps, which otherwise flash as the animation loops. Pixels within
+-skipsolidtolerance of each other count as the same colour & -verbose reports how many frames
+were dropped.
+
+The -trimframes parameter drops the still frames at the start & end of captures which sit
+still before & after the action, keeping the frames from where the motion starts to where it
+stops. Frames whose pixels are all within -trimframestolerance of the frame next to them
+count as still & the number of frames trimmed from each end is reported.
+
+The -targetframes parameter gives every GIF the same number of frames whatever the number of
+source images by dropping or repeating frames spread evenly through the animation. It counts
+the frames left once -clip has selected images, images have been skipped & -interpolate has
+added frames. The animation keeps its duration since the delays of dropped frames go to the
+frames kept & repeated frames share the delay of the frame they repeat. -rotateframes then
+shifts the resampled frames.
+
+The -repeat parameter lingers on moments for a stutter effect by playing frames or ranges of
+frames more than once, eg. -repeat=3-5x2,8x3 plays frames 3 to 5 twice over & then frame 8
+three times. Frames are numbered from 0 in the order they are played after -targetframes &
+-rotateframes & repeated frames keep their delays so the animation gets longer.
+
+The -trim parameter removes borders of uniform colour (matching the top-left pixel within
+-trimtolerance) from each image individually. Since that can give frames of different sizes,
+-trimuniform instead finds the borders from the first image & trims every image by the same
+amount. An explicit crop is applied to the trimmed image.
+
+goanigiffy [flags] [image files...]
+
+Image files can be given after the flags instead of a -src glob, eg. goanigiffy frame*.png,
+in which case -src is ignored. They are sorted alphabetically like glob matches unless
+-nosort is given which keeps them in the order given. A -src of "-" reads a single image from
+standard input for pipelines, eg. convert logo.svg png:- | goanigiffy -src=- -dest=logo.gif.
+Giving "-" several times as image files repeats that image which can be animated with effects
+like -spin, eg. goanigiffy -spin=360 - - - - - - - - < logo.png.
+
+A -src naming a directory uses all the gif, jpg & png images in it, eg. goanigiffy -src=frames,
+& -recursive also uses those in its subdirectories. The images are sorted by their path unless
+-nosort is given.
+
+A -src naming a single animated GIF edits that GIF, eg. to fix a GIF that plays too fast with
+goanigiffy -src=fast.gif -speed=2 -dest=slow.gif. Each of its frames is used as an image so
+it can also be cropped, scaled & so on. The GIF keeps its own delays & looping, which -speed
+scales, unless -delay, the fps of -clip or -noloop replace them.
+
+The -threads parameter sets how many images are processed at once. It defaults to the GOMAXPROCS
+environment variable, or the number of CPUs when that isn't set, & only limits goanigiffy's own
+work so the Go runtime settings are left alone.
 
 Usage of goanigiffy:
+  -accumulate=false: composite each frame with all the frames before it for a light trails effect
+  -accumulatemode="max": how frames are accumulated, valid values are max, lighten, add
+  -adaptivecolors=false: give each frame only as many colours as it uses, up to 256, for smaller GIFs
+  -autodelay=false: reproduce the original playback speed from the -timestamps file or the times in file names
+  -autolevels="none": stretch contrast to the full range, valid values are none, frame, global
+  -autowb="none": gray world white balance to remove colour casts, valid values are none, frame, global
+  -background="#000000": hex colour that transparent images are flattened onto
+  -clip="": select a section by time with a spec like start=2s,end=6s,fps=15
+  -colortable="auto": where frame palettes are written, valid values are auto, global, local
+  -comment="": optional text to embed in the GIF as a comment
+  -credit=false: embed a created by goanigiffy comment in the GIF
+  -crop="": crop rectangle as left,top,right,bottom or left,top,WxH instead of the individual crop flags
+  -cropaspect="": center crop images to the largest rectangle of an aspect ratio like 16:9 or 1:1
+  -cropgrid="": split each image into a grid of rows x columns tiles like 4x8 with each tile a frame
   -cropheight=-1: height of cropped image, -1 specified full height
   -cropleft=0: left co-ordinate for crop to start
+  -cropfollow="": optional Haar cascade file used to pan a fixed size crop to keep the detected subject centered
+  -croppath="": optional file of left,top crop offsets, one line per image, to pan a fixed size crop
   -croptop=0: top co-ordinate for crop to start
   -cropwidth=-1: width of cropped image, -1 specifies full width
-  -delay=3: delay time between frame in hundredths of a second
-  -dest="movie.gif": a destination filename for the animated gif
+  -delay="3": delay time between frame in hundredths of a second or a comma separated list repeated over the frames
+  -denoise=false: reduce sensor noise & grain with a median filter which also makes GIFs smaller
+  -denoisestrength=1: radius in pixels of the -denoise filter, 0 disables it
+  -dest="movie.gif": a destination filename for the animated gif or a comma separated list of filenames
+  -dither="floydsteinberg": valid values are floydsteinberg, bayer, none
+  -docrop=true: apply the crop operation, false skips it whatever the crop flags are
+  -doflip=true: apply the flip operation, false skips it whatever the flip flag is
+  -dorotate=true: apply the rotate operation, false skips it whatever the rotate flag is
+  -doscale=true: apply the scale operation, false skips it whatever the scale flags are
+  -dpi=0: dots per inch of the source images so -crop can be given in inches or millimetres like 1in or 25mm
+  -dumppalette="": optional filename to write the GIF palette to as a png swatch or a text list of hex colours
+  -exclude="": skip source files whose name matches this regular expression
+  -exiftransforms=false: apply crop & rotate hints embedded in the EXIF or comments of JPEG images
+  -fitanchor="center": where frames sit on a square-N preset canvas, eg. center, top, bottom, left, right, topleft
   -flip="none": valid falues are none, horizontal, vertical
-  -rotate=0: valid values are 0, 90, 180, 270
-  -scale=1: scaling factor to apply if any
-  -src="*.jpg": a glob pattern for source images. defaults to *.jpg
+  -fuse="": optional png or jpg filename to merge bracketed exposures into one still instead of writing a GIF
+  -halftone=false: render frames as a black & white halftone dot pattern
+  -halftonedotsize=8: spacing of halftone dots in pixels, 0 disables halftone
+  -hashmanifest="": optional file recording the hash of each source image & the settings used
+  -htmlpreview="": optional HTML file to write showing the GIF with a summary & the parameters used
+  -interlace=false: write interlaced frames which viewers can show progressively while loading
+  -interpolate=false: smooth choppy sequences by blending in-between frames keeping the same duration
+  -interpolatefactor=1: number of frames -interpolate inserts between each pair of frames
+  -jitter=0: randomly vary each frame delay by up to this percentage either way, 0 disables it
+  -linearresize=false: resize in linear light instead of sRGB colour space
+  -livepreview=0: rewrite the destination with the frames done so far every this many frames, 0 disables it
+  -loadrecipe="": optional JSON recipe file of settings to use, flags on the command line take precedence
+  -loopdelay=0: extra delay in hundredths of a second added to the last frame to pause before looping
+  -lut="": optional .cube 3D LUT file to colour grade the frames with
+  -match="": only use source files whose name matches this regular expression
+  -maxbytes=0: shrink colours & then frame size until the GIF is at most this many bytes, 0 for no limit
+  -maxduration=0: drop the frames after this many seconds of playing time, 0 for no limit
+  -minbrowserdelay=false: raise delays below 2 to 2 since browsers play them much slower as 10
+  -mirror=false: make frames symmetric by reflecting one half onto the other
+  -mirroraxis="vertical": line -mirror reflects across, valid values are vertical, horizontal, both, none
+  -mkdir=false: create the directories of -dest if they don't exist
+  -motionblur=false: blend each frame with the frames before it to simulate motion blur
+  -motionblurstrength=0.5: weight (0-1) given to the preceding frames in motion blur, 0 disables it
+  -noloop=false: play the animation once & hold the last frame instead of looping forever
+  -nosort=false: keep the order images are found in instead of sorting them alphabetically
+  -noupscale=false: never enlarge images, scale factors above 1 are treated as 1
+  -order="crop,scale,rotate,flip": order to apply the crop, scale, rotate & flip operations in
+  -overlap=false: smooth motion by ghosting part of the next frame into each frame without adding frames
+  -overlapamount=0.3: weight (0-1) given to the next frame by -overlap, 0 disables it
+  -palette="plan9": fixed palette for all frames unless -palettefile is given, valid values are plan9, websafe
+  -palettefile="": optional file of 2-256 hex colours to use as a fixed palette for all frames
+  -parsetiming=false: use capture times in file names like frame_001523ms.jpg to time the frames
+  -pixelate=0: block size in pixels for a mosaic effect, 0 or 1 disables it
+  -poster="": optional filename to also save the first frame as a png or jpg poster image
+  -preset="": output size preset, one of 240p, 360p, 480p, 720p, 1080p or square-N for an N x N canvas
+  -quality=0: 1 (fast & small) to 10 (best) choosing the palette & dithering together, 0 uses the individual flags
+  -recursive=false: also use images in subdirectories when -src is a directory
+  -repeat="": frames or ranges to play more than once like 3-5x2,8x3 which plays frames 3 to 5 twice & frame 8 three times
+  -retry=0: number of times to retry reading an image that fails to open before skipping it
+  -reverseoutput=false: also write the frames in reverse to a second GIF named like the destination with _reverse added
+  -rotate="0": valid values are 0, 90, 180, 270 or cw (90), ccw (270), flip (180)
+  -rotateauto=false: level tilted horizons by detecting & undoing a tilt of up to 15 degrees in each frame
+  -rotateexpand=false: enlarge the canvas of -rotateauto & -spin frames to fit the whole rotated image instead of clipping it
+  -rotateframes=0: cyclically shift frame order so this frame number comes first
+  -saverecipe="": optional JSON recipe file to save the settings used to
+  -scale="1": scaling factor to apply if any, either like 0.5 or like 50%
+  -scaleend=0: scaling factor for the last image of a zoom, used with -scalestart instead of -scale
+  -scalestart=0: scaling factor for the first image of a zoom, used with -scaleend instead of -scale
+  -scanlineintensity=0.5: how much (0-1) -scanlines darkens its rows, 0 disables it
+  -scanlines=false: darken every other row of pixels for a retro CRT look
+  -seed=0: seed for the random variation of -jitter, 0 picks a new seed every run
+  -sidecartext=false: draw the text in foo.txt onto the frame made from foo.jpg where such a file exists
+  -skipsolid=false: drop frames which are a single solid colour such as black frames at scene cuts
+  -skipsolidtolerance=8: how far (0-255) pixels can differ & still count as one solid colour for -skipsolid
+  -skipunchanged=false: do nothing if the -hashmanifest file shows the sources & settings are unchanged
+  -smartdither=false: skip dithering for simple frames with few colours such as screen captures
+  -smartditherthreshold=256: frames with at most this many colours are not dithered under -smartdither
+  -speed=1: multiplies every frame delay, 0.5 plays twice as fast & 2 at half speed
+  -spin=0: rotate the frames by an increasing angle adding up to this many degrees over the animation
+  -spindirection="cw": direction of -spin, valid values are cw, ccw
+  -spritesheet="": optional png or jpg filename to also save all frames as a sprite sheet with a css animation
+  -spritesheetcols=0: number of columns in the -spritesheet grid, 0 puts all frames in one row
+  -src="*.jpg": a glob pattern or directory of source images or - to read one image from standard input. defaults to *.jpg
+  -stabilizepalette=false: match the colours picked for each frame to those of the frame before to stop them shimmering
+  -stabilizetolerance=8: how far (0-255) colours can differ & still be matched under -stabilizepalette
+  -standardize=false: pad or crop all images to the most common image size
+  -targetframes=0: drop or repeat frames evenly to give exactly this many frames in the same time, 0 keeps all frames
+  -threads=<number of CPUs>: number of images to process in parallel, 1 processes serially
+  -thumb="": optional filename to also save a small png or jpg thumbnail of one frame
+  -thumbindex=0: frame number to use for the thumbnail
+  -thumbsize=160: maximum width & height of the thumbnail
+  -timestamps="": optional file of frame times in milliseconds, one per line, like ffmpeg -f mkvtimestamp_v2 writes, for -autodelay
+  -timing=false: report the time spent decoding, in each operation, quantizing & encoding
+  -timingcsv="": optional csv file of the microseconds spent on each image in each stage, a row per frame of an animated GIF & per -cropgrid tile
+  -timingfile="": optional .srt or .vtt subtitle file listing each frame's time & source image
+  -trim=false: automatically crop away uniform colour borders from each image
+  -trimframes=false: drop the still frames at the start & end before & after the motion
+  -trimframestolerance=8: how far (0-255) pixels can differ & still count as the same under -trimframes
+  -trimtolerance=0: how far (0-255) a pixel can differ from the border colour & still be trimmed
+  -trimuniform=false: trim all images by the borders found in the first image
   -verbose=false: show in-process messages
+  -verify=false: re-read the written GIF to check it is complete
+  -version=false: print version information and exit
 
 Sources: https://github.com/srinathh/goanigiffy
 */
@@ -48,19 +486,140 @@ package main
 import (
 	"bytes"
 	"flag"
+	"fmt"
 	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
 	"image/gif"
 	_ "image/jpeg"
 	_ "image/png"
+	"io"
+	"io/ioutil"
 	"log"
+	"math"
+	"math/rand"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/disintegration/imaging"
+	xdraw "golang.org/x/image/draw"
+)
+
+//Version information. These are set at build time via
+//  go build -ldflags "-X main.version=1.1 -X main.commit=abc123 -X main.builddate=2014-08-01"
+var (
+	version   = "dev"
+	commit    = "unknown"
+	builddate = "unknown"
 )
 
+//boundsChange describes how an operation changed the image dimensions for verbose logs
+func boundsChange(before, after image.Rectangle) string {
+	return fmt.Sprintf("(%d, %d) -> (%d, %d)", before.Dx(), before.Dy(), after.Dx(), after.Dy())
+}
+
+//FindTrim returns the bounds of img once borders of uniform colour are removed. The border
+//colour is taken from the top-left pixel & pixels whose channels all lie within tolerance
+//of it are treated as border
+func FindTrim(tolerance int, img image.Image) image.Rectangle {
+	src := imaging.Clone(img)
+	b := src.Bounds()
+	ref := src.NRGBAAt(b.Min.X, b.Min.Y)
+
+	within := func(a, b uint8) bool {
+		d := int(a) - int(b)
+		return d <= tolerance && d >= -tolerance
+	}
+	isBorder := func(x, y int) bool {
+		c := src.NRGBAAt(x, y)
+		return within(c.R, ref.R) && within(c.G, ref.G) && within(c.B, ref.B) && within(c.A, ref.A)
+	}
+	rowIsBorder := func(y, x0, x1 int) bool {
+		for x := x0; x < x1; x++ {
+			if !isBorder(x, y) {
+				return false
+			}
+		}
+		return true
+	}
+	colIsBorder := func(x, y0, y1 int) bool {
+		for y := y0; y < y1; y++ {
+			if !isBorder(x, y) {
+				return false
+			}
+		}
+		return true
+	}
+
+	r := b
+	for r.Min.Y < r.Max.Y && rowIsBorder(r.Min.Y, r.Min.X, r.Max.X) {
+		r.Min.Y++
+	}
+	for r.Max.Y > r.Min.Y && rowIsBorder(r.Max.Y-1, r.Min.X, r.Max.X) {
+		r.Max.Y--
+	}
+	for r.Min.X < r.Max.X && colIsBorder(r.Min.X, r.Min.Y, r.Max.Y) {
+		r.Min.X++
+	}
+	for r.Max.X > r.Min.X && colIsBorder(r.Max.X-1, r.Min.Y, r.Max.Y) {
+		r.Max.X--
+	}
+
+	//A completely uniform image has nothing to keep so leave it untouched
+	if r.Empty() {
+		return b
+	}
+	return r
+}
+
+//IsSolid reports whether every pixel of img has channels within tolerance of the top-left
+//pixel making it essentially a single solid colour
+func IsSolid(tolerance int, img image.Image) bool {
+	src := imaging.Clone(img)
+	if len(src.Pix) == 0 {
+		return false
+	}
+	for i := 4; i < len(src.Pix); i++ {
+		d := int(src.Pix[i]) - int(src.Pix[i%4])
+		if d > tolerance || d < -tolerance {
+			return false
+		}
+	}
+	return true
+}
+
+//TrimImage crops img to the trim rectangle found by FindTrim. It is a no-op if the trim
+//covers the whole image
+func TrimImage(trim image.Rectangle, img image.Image, verbose bool) image.Image {
+	if trim.Eq(img.Bounds()) || trim.Empty() {
+		return img
+	}
+	before := img.Bounds()
+	img = imaging.Crop(img, trim)
+	if verbose {
+		log.Printf("Trimming borders to (%d,%d)->(%d,%d) : %s", trim.Min.X, trim.Min.Y, trim.Max.X-1, trim.Max.Y-1, boundsChange(before, img.Bounds()))
+	}
+	return img
+}
+
+//CheckCrop returns an error if an explicit crop extends beyond the bounds b of the image
+//being cropped. A width or height of -1 means the full width or height & always fits
+func CheckCrop(cropleft, croptop, cropwidth, cropheight int, b image.Rectangle) error {
+	if (cropwidth != -1 && cropleft+cropwidth > b.Dx()) || (cropheight != -1 && croptop+cropheight > b.Dy()) {
+		return fmt.Errorf("crop at (%d,%d) of %dx%d extends beyond the %dx%d image", cropleft, croptop, cropwidth, cropheight, b.Dx(), b.Dy())
+	}
+	return nil
+}
+
 func CropImage(cropleft, croptop, cropwidth, cropheight int, img image.Image, verbose bool) image.Image {
 	//Crop operation. Ignore if there is no crop operation specified
 	if !(cropwidth == -1 && cropheight == -1 && cropleft == 0 && croptop == 0) {
@@ -70,34 +629,165 @@ func CropImage(cropleft, croptop, cropwidth, cropheight int, img image.Image, ve
 		if cropheight == -1 {
 			cropheight = img.Bounds().Dy()
 		}
+		before := img.Bounds()
+		img = imaging.Crop(img, image.Rect(cropleft, croptop, cropleft+cropwidth-1, croptop+cropheight-1))
 		if verbose {
-			log.Printf("Cropping original image at (%d,%d)->(%d,%d)", cropleft, croptop, cropleft+cropwidth-1, croptop+cropheight-1)
+			log.Printf("Cropping image at (%d,%d)->(%d,%d) : %s", cropleft, croptop, cropleft+cropwidth-1, croptop+cropheight-1, boundsChange(before, img.Bounds()))
 		}
-		img = imaging.Crop(img, image.Rect(cropleft, croptop, cropleft+cropwidth-1, croptop+cropheight-1))
 	}
 	return img
 }
 
-func ScaleImage(scale float64, img image.Image, verbose bool) image.Image {
+//AspectCropImage crops the largest rectangle with the aspect ratio width:height out of the
+//center of img. Images which already have that ratio are left alone
+func AspectCropImage(width, height int, img image.Image, verbose bool) image.Image {
+	before := img.Bounds()
+	w, h := before.Dx(), before.Dy()
+	if w*height > h*width {
+		w = h * width / height
+	} else {
+		h = w * height / width
+	}
+	if w == before.Dx() && h == before.Dy() {
+		return img
+	}
+	img = imaging.CropCenter(img, w, h)
+	if verbose {
+		log.Printf("Cropping image to aspect ratio %d:%d : %s", width, height, boundsChange(before, img.Bounds()))
+	}
+	return img
+}
+
+//srgbToLinear maps 8 bit sRGB channel values to 16 bit linear light & linearToSRGB maps 16 bit
+//linear light back to 8 bit sRGB. Linear light is kept at 16 bits since 8 bits can't tell
+//apart the dark sRGB values which are bunched up near 0 in linear light
+var srgbToLinear [256]uint16
+var linearToSRGB [65536]uint8
+
+func init() {
+	for i := range srgbToLinear {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		srgbToLinear[i] = uint16(v*0xffff + 0.5)
+	}
+	for i := range linearToSRGB {
+		v := float64(i) / 0xffff
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		linearToSRGB[i] = uint8(v*255 + 0.5)
+	}
+}
+
+//lanczos is the Lanczos filter with 3 lobes that imaging.Lanczos uses, for resizing in linear
+//light
+var lanczos = &xdraw.Kernel{Support: 3, At: func(t float64) float64 {
+	if t == 0 {
+		return 1
+	}
+	x := math.Pi * t
+	return 3 * math.Sin(x) * math.Sin(x/3) / (x * x)
+}}
+
+//ConvertImage returns a copy of img with the colour channels mapped through the lookup table
+func ConvertImage(lut *[256]uint8, img image.Image) *image.NRGBA {
+	dst := imaging.Clone(img)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		dst.Pix[i] = lut[dst.Pix[i]]
+		dst.Pix[i+1] = lut[dst.Pix[i+1]]
+		dst.Pix[i+2] = lut[dst.Pix[i+2]]
+	}
+	return dst
+}
+
+//ResizeLinear resizes img to width x height in 16 bit linear light with the Lanczos filter
+func ResizeLinear(img image.Image, width, height int) *image.NRGBA {
+	src := imaging.Clone(img)
+	//Colours are premultiplied by alpha for resizing as image.RGBA64 expects
+	lin := image.NewRGBA64(src.Bounds())
+	for i := 0; i < len(src.Pix); i += 4 {
+		a := uint32(src.Pix[i+3]) * 0x101
+		for c := 0; c < 3; c++ {
+			v := uint32(srgbToLinear[src.Pix[i+c]]) * a / 0xffff
+			lin.Pix[i*2+c*2], lin.Pix[i*2+c*2+1] = uint8(v>>8), uint8(v)
+		}
+		lin.Pix[i*2+6], lin.Pix[i*2+7] = uint8(a>>8), uint8(a)
+	}
+	resized := image.NewRGBA64(image.Rect(0, 0, width, height))
+	lanczos.Scale(resized, resized.Bounds(), lin, lin.Bounds(), xdraw.Src, nil)
+
+	dst := image.NewNRGBA(resized.Bounds())
+	for i := 0; i < len(dst.Pix); i += 4 {
+		a := uint32(resized.Pix[i*2+6])<<8 | uint32(resized.Pix[i*2+7])
+		dst.Pix[i+3] = uint8(a >> 8)
+		if a == 0 {
+			continue
+		}
+		for c := 0; c < 3; c++ {
+			v := (uint32(resized.Pix[i*2+c*2])<<8 | uint32(resized.Pix[i*2+c*2+1])) * 0xffff / a
+			if v > 0xffff {
+				v = 0xffff
+			}
+			dst.Pix[i+c] = linearToSRGB[v]
+		}
+	}
+	return dst
+}
+
+//ScaleImage resizes img by the scale factor. If linear is set, the resize is done in linear
+//light rather than in sRGB which avoids darkening high contrast detail on big downscales
+func ScaleImage(scale float64, linear bool, img image.Image, verbose bool) image.Image {
 	//Scale operation. Ignore if scale is 1.0
 	if scale != 1.0 {
 		newwidth := int(float64(img.Bounds().Dx()) * scale)
 		newheight := int(float64(img.Bounds().Dy()) * scale)
 
+		before := img.Bounds()
+		if linear {
+			img = ResizeLinear(img, newwidth, newheight)
+		} else {
+			img = imaging.Resize(img, newwidth, newheight, imaging.Lanczos)
+		}
 		if verbose {
-			log.Printf("Scaling image from (%d, %d) -> (%d, %d)", img.Bounds().Dx(), img.Bounds().Dy(), newwidth, newheight)
+			log.Printf("Scaling image by %g : %s", scale, boundsChange(before, img.Bounds()))
 		}
-		img = imaging.Resize(img, newwidth, newheight, imaging.Lanczos)
 	}
 	return img
 
 }
 
+//ZoomImage scales img by scale & then crops it around the center to the size img would be
+//at canvasscale. With canvasscale the smallest scale of a zoom, every frame ends up the same
+//size with the content growing or shrinking inside it
+func ZoomImage(scale, canvasscale float64, linear bool, img image.Image, verbose bool) image.Image {
+	w := int(float64(img.Bounds().Dx()) * canvasscale)
+	h := int(float64(img.Bounds().Dy()) * canvasscale)
+	img = ScaleImage(scale, linear, img, verbose)
+	b := img.Bounds()
+	if b.Dx() <= w && b.Dy() <= h {
+		return img
+	}
+	before := b
+	left, top := (b.Dx()-w)/2, (b.Dy()-h)/2
+	img = imaging.Crop(img, image.Rect(left, top, left+w, top+h))
+	if verbose {
+		log.Printf("Cropping zoomed image to canvas : %s", boundsChange(before, img.Bounds()))
+	}
+	return img
+}
+
+//rotateNames maps the values accepted for -rotate to the clockwise rotation in degrees
+var rotateNames = map[string]int{"0": 0, "90": 90, "180": 180, "270": 270, "cw": 90, "ccw": 270, "flip": 180}
+
 func RotateImage(rotate int, img image.Image, verbose bool) image.Image {
 	//Rotate operation. Ignore if rotate is 0
-	if rotate != 0 && verbose {
-		log.Printf("Rotating by %d", rotate)
-	}
+	before := img.Bounds()
 	switch rotate {
 	case 90:
 		img = imaging.Rotate90(img)
@@ -106,118 +796,2490 @@ func RotateImage(rotate int, img image.Image, verbose bool) image.Image {
 	case 270:
 		img = imaging.Rotate270(img)
 	}
+	if rotate != 0 && verbose {
+		log.Printf("Rotating by %d : %s", rotate, boundsChange(before, img.Bounds()))
+	}
+	return img
+}
+
+//RotateAngleImage rotates img clockwise by an arbitrary angle in degrees filling the exposed
+//corners with fill. The result is cropped or padded around the center to canvas, or to the
+//original size if canvas is zero
+func RotateAngleImage(angle float64, canvas image.Point, fill color.Color, img image.Image, verbose bool) image.Image {
+	before := img.Bounds()
+	if canvas == (image.Point{}) {
+		canvas = before.Size()
+	}
+	if math.Mod(angle, 360) == 0 && canvas == before.Size() {
+		return img
+	}
+	//imaging.Rotate turns counter-clockwise & grows the canvas to fit the rotated image
+	rotated := imaging.Rotate(img, -angle, fill)
+	img = imaging.PasteCenter(imaging.New(canvas.X, canvas.Y, fill), rotated)
+	if verbose {
+		log.Printf("Rotating by %.2f degrees : %s", angle, boundsChange(before, img.Bounds()))
+	}
 	return img
 }
 
+//RotatedSize returns the size of the smallest canvas that fits an image of size rotated by
+//any of the angles in degrees without clipping its corners
+func RotatedSize(size image.Point, angles ...float64) image.Point {
+	var fit image.Point
+	for _, angle := range angles {
+		sin, cos := math.Sincos(angle * math.Pi / 180)
+		sin, cos = math.Abs(sin), math.Abs(cos)
+		//a small tolerance stops rounding errors adding a pixel at right angles
+		w := int(math.Ceil(float64(size.X)*cos + float64(size.Y)*sin - 1e-6))
+		h := int(math.Ceil(float64(size.X)*sin + float64(size.Y)*cos - 1e-6))
+		if w > fit.X {
+			fit.X = w
+		}
+		if h > fit.Y {
+			fit.Y = h
+		}
+	}
+	return fit
+}
+
+//maxTilt is the largest tilt in degrees that FindTilt looks for
+const maxTilt = 15
+
+//FindTilt estimates how many degrees the horizon of img is tilted clockwise from the
+//dominant angle of its near horizontal edges. Edge angles are found from the brightness
+//gradient & collected in a histogram weighted by edge strength so long strong edges like a
+//horizon win. Only tilts within maxTilt degrees are considered
+func FindTilt(img image.Image) float64 {
+	//Edges are found on a smaller copy which is faster & less sensitive to noise. A box
+	//filter aliases straight edges into steps & the Sobel angle of an edge only a pixel or
+	//two wide leans towards the axes, so the copy is resized smoothly & softened a little
+	small := imaging.Grayscale(imaging.Blur(imaging.Fit(img, 400, 400, imaging.Linear), 1.5))
+	b := small.Bounds()
+	const binsPerDegree = 2
+	hist := make([]float64, 2*maxTilt*binsPerDegree+1)
+	at := func(x, y int) float64 {
+		return float64(small.Pix[small.PixOffset(x, y)])
+	}
+	for y := 1; y < b.Dy()-1; y++ {
+		for x := 1; x < b.Dx()-1; x++ {
+			//Sobel gradient
+			gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+			gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+			if gy == 0 || math.Abs(gx) > math.Abs(gy) {
+				continue
+			}
+			//The edge runs at right angles to the gradient
+			tilt := math.Atan(-gx/gy) * 180 / math.Pi
+			if math.Abs(tilt) > maxTilt {
+				continue
+			}
+			hist[int(math.Floor((tilt+maxTilt)*binsPerDegree+0.5))] += gx*gx + gy*gy
+		}
+	}
+	best, bestweight := maxTilt*binsPerDegree, 0.0
+	for j := range hist {
+		//Smooth over neighbouring bins so an edge falling between two bins isn't split
+		weight := hist[j]
+		if j > 0 {
+			weight += hist[j-1] / 2
+		}
+		if j+1 < len(hist) {
+			weight += hist[j+1] / 2
+		}
+		if weight > bestweight {
+			best, bestweight = j, weight
+		}
+	}
+	return float64(best)/binsPerDegree - maxTilt
+}
+
 //FlipImage takes a string
 func FlipImage(flip string, img image.Image, verbose bool) image.Image {
 	//Flip operation
-	if flip != "none" && verbose {
-		log.Printf("Flipping %s", flip)
-	}
-
+	before := img.Bounds()
 	switch flip {
 	case "horizontal":
 		img = imaging.FlipH(img)
 	case "vertical":
 		img = imaging.FlipV(img)
 	}
+	if flip != "none" && verbose {
+		log.Printf("Flipping %s : %s", flip, boundsChange(before, img.Bounds()))
+	}
 	return img
 }
 
-func main() {
+//MirrorImage makes img symmetric by reflecting its left half onto its right half for a
+//"vertical" axis, its top half onto its bottom half for "horizontal" or both for "both"
+func MirrorImage(axis string, img image.Image, verbose bool) image.Image {
+	if axis == "none" {
+		return img
+	}
+	before := img.Bounds()
+	dst := imaging.Clone(img)
+	w, h := dst.Bounds().Dx(), dst.Bounds().Dy()
+	if axis == "vertical" || axis == "both" {
+		half := imaging.FlipH(imaging.Crop(dst, image.Rect(0, 0, (w+1)/2, h)))
+		dst = imaging.Paste(dst, half, image.Pt(w-half.Bounds().Dx(), 0))
+	}
+	if axis == "horizontal" || axis == "both" {
+		half := imaging.FlipV(imaging.Crop(dst, image.Rect(0, 0, w, (h+1)/2)))
+		dst = imaging.Paste(dst, half, image.Pt(0, h-half.Bounds().Dy()))
+	}
+	if verbose {
+		log.Printf("Mirroring across %s axis : %s", axis, boundsChange(before, dst.Bounds()))
+	}
+	return dst
+}
 
-	runtime.GOMAXPROCS(runtime.NumCPU())
+//FitImage shrinks img to fit within width x height keeping its aspect ratio. Images which
+//already fit are left alone. If pad is set, the result is placed on a black canvas of
+//exactly width x height at the given anchor
+func FitImage(width, height int, pad bool, anchor imaging.Anchor, img image.Image, verbose bool) image.Image {
+	before := img.Bounds()
+	img = imaging.Fit(img, width, height, imaging.Lanczos)
+	if pad && !(img.Bounds().Dx() == width && img.Bounds().Dy() == height) {
+		img = imaging.Paste(imaging.New(width, height, color.Black), img, anchorPoint(width, height, img.Bounds(), anchor))
+	}
+	if verbose && !before.Eq(img.Bounds()) {
+		log.Printf("Fitting image in (%d, %d) : %s", width, height, boundsChange(before, img.Bounds()))
+	}
+	return img
+}
 
-	srcglob := flag.String("src", "*.jpg", "a glob pattern for source images. defaults to *.jpg")
-	destname := flag.String("dest", "movie.gif", "a destination filename for the animated gif")
-	cropleft := flag.Int("cropleft", 0, "left co-ordinate for crop to start")
-	croptop := flag.Int("croptop", 0, "top co-ordinate for crop to start")
-	cropwidth := flag.Int("cropwidth", -1, "width of cropped image, -1 specifies full width")
-	cropheight := flag.Int("cropheight", -1, "height of cropped image, -1 specified full height")
-	delay := flag.Int("delay", 3, "delay time between frame in hundredths of a second")
-	verbose := flag.Bool("verbose", false, "show in-process messages")
-	scale := flag.Float64("scale", 1.0, "scaling factor to apply if any")
-	rotate := flag.Int("rotate", 0, "valid values are 0, 90, 180, 270")
-	flip := flag.String("flip", "none", "valid falues are none, horizontal, vertical")
+//anchorPoint returns where the top-left of an image with bounds b goes to place it at
+//anchor within a width x height canvas
+func anchorPoint(width, height int, b image.Rectangle, anchor imaging.Anchor) image.Point {
+	x, y := (width-b.Dx())/2, (height-b.Dy())/2
+	switch anchor {
+	case imaging.TopLeft, imaging.Top, imaging.TopRight:
+		y = 0
+	case imaging.BottomLeft, imaging.Bottom, imaging.BottomRight:
+		y = height - b.Dy()
+	}
+	switch anchor {
+	case imaging.TopLeft, imaging.Left, imaging.BottomLeft:
+		x = 0
+	case imaging.TopRight, imaging.Right, imaging.BottomRight:
+		x = width - b.Dx()
+	}
+	return image.Pt(x, y)
+}
 
-	flag.Parse()
+//HalftoneImage renders img as black dots on white like a newspaper print. The image is
+//split into cells of dotsize pixels each holding one dot whose area grows with the darkness
+//of the cell. A dot size of 0 is a no-op
+func HalftoneImage(dotsize int, img image.Image, verbose bool) image.Image {
+	if dotsize <= 0 {
+		return img
+	}
+	src := imaging.Grayscale(img)
+	b := src.Bounds()
+	dst := imaging.New(b.Dx(), b.Dy(), color.White)
+	for cy := 0; cy < b.Dy(); cy += dotsize {
+		for cx := 0; cx < b.Dx(); cx += dotsize {
+			cell := image.Rect(cx, cy, cx+dotsize, cy+dotsize).Intersect(b)
+			sum, n := 0, 0
+			for y := cell.Min.Y; y < cell.Max.Y; y++ {
+				for x := cell.Min.X; x < cell.Max.X; x++ {
+					sum += int(src.Pix[src.PixOffset(x, y)])
+					n++
+				}
+			}
+			//Dot area scales with darkness. A fully black cell gets a dot reaching its corners
+			darkness := 1 - float64(sum)/float64(n*255)
+			radius := float64(dotsize) / math.Sqrt2 * math.Sqrt(darkness)
+			centerx, centery := float64(cx)+float64(dotsize)/2, float64(cy)+float64(dotsize)/2
+			for y := cell.Min.Y; y < cell.Max.Y; y++ {
+				for x := cell.Min.X; x < cell.Max.X; x++ {
+					dx, dy := float64(x)+0.5-centerx, float64(y)+0.5-centery
+					if dx*dx+dy*dy <= radius*radius {
+						i := dst.PixOffset(x, y)
+						dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2] = 0, 0, 0
+					}
+				}
+			}
+		}
+	}
+	if verbose {
+		log.Printf("Rendering as halftone with %d pixel dots", dotsize)
+	}
+	return dst
+}
 
-	if !(*rotate == 0 || *rotate == 90 || *rotate == 180 || *rotate == 270) {
-		log.Printf("rotate flag must be one of 0, 90, 180 or 270")
-		flag.PrintDefaults()
-		os.Exit(1)
+//FlattenImage composites img onto a solid background colour if it has any transparency
+func FlattenImage(bg color.Color, img image.Image, verbose bool) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+	if verbose {
+		log.Printf("Flattening transparent image onto background")
 	}
+	b := img.Bounds()
+	return imaging.Overlay(imaging.New(b.Dx(), b.Dy(), bg), img, image.Pt(0, 0), 1.0)
+}
 
-	if !(*flip == "none" || *flip == "horizontal" || *flip == "vertical") {
-		log.Printf("flip flag must be one of none, horizontal or vertical")
-		flag.PrintDefaults()
-		os.Exit(1)
+//TileImage returns tile number tile of img split into a grid of rows x cols equal tiles,
+//counting along each row first. Tiles are the image size divided by the grid rounded down so
+//any pixels left over at the right & bottom edges are dropped
+func TileImage(tile, rows, cols int, img image.Image, verbose bool) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx()/cols, b.Dy()/rows
+	x, y := b.Min.X+w*(tile%cols), b.Min.Y+h*(tile/cols)
+	dst := imaging.Crop(img, image.Rect(x, y, x+w, y+h))
+	if verbose {
+		log.Printf("Taking tile %d of a %dx%d grid : %s", tile, rows, cols, boundsChange(b, dst.Bounds()))
 	}
+	return dst
+}
 
-	srcfilenames, err := filepath.Glob(*srcglob)
-	if err != nil {
-		log.Fatalf("Error in globbing source file pattern %s : %s", *srcglob, err)
+//DenoiseImage reduces sensor noise & grain in img with a median filter which replaces each
+//pixel with the median of the pixels within radius of it. Unlike a blur this keeps edges hard.
+//Noise defeats the GIF encoder so this usually makes the GIF smaller too. A radius of 0 is a
+//no-op
+func DenoiseImage(radius int, img image.Image, verbose bool) image.Image {
+	if radius <= 0 {
+		return img
 	}
+	src := imaging.Clone(img)
+	dst := imaging.New(src.Bounds().Dx(), src.Bounds().Dy(), color.NRGBA{})
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	window := make([]uint8, 0, (2*radius+1)*(2*radius+1))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			for c := 0; c < 4; c++ {
+				window = window[:0]
+				for wy := y - radius; wy <= y+radius; wy++ {
+					row := clampInt(wy, 0, h-1) * src.Stride
+					for wx := x - radius; wx <= x+radius; wx++ {
+						//insertion sort as the window is small
+						v := src.Pix[row+4*clampInt(wx, 0, w-1)+c]
+						i := len(window)
+						window = append(window, v)
+						for ; i > 0 && window[i-1] > v; i-- {
+							window[i] = window[i-1]
+						}
+						window[i] = v
+					}
+				}
+				dst.Pix[y*dst.Stride+4*x+c] = window[len(window)/2]
+			}
+		}
+	}
+	if verbose {
+		log.Printf("Denoising with radius %d : %s", radius, boundsChange(img.Bounds(), dst.Bounds()))
+	}
+	return dst
+}
 
-	if len(srcfilenames) == 0 {
-		log.Fatalf("No source images found via pattern %s", *srcglob)
+//clampInt limits v to between lo & hi
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
 	}
+	return v
+}
 
-	if *verbose {
-		log.Printf("Found %d images to parse", len(srcfilenames))
+//PixelateImage gives img a blocky mosaic look with blocks of the given size in pixels. The
+//image is shrunk by averaging each block & then enlarged back with nearest-neighbour so the
+//blocks keep hard edges. Block sizes of 0 or 1 are a no-op
+func PixelateImage(block int, img image.Image, verbose bool) image.Image {
+	if block <= 1 {
+		return img
+	}
+	before := img.Bounds()
+	w, h := before.Dx(), before.Dy()
+	smallw, smallh := (w+block-1)/block, (h+block-1)/block
+	img = imaging.Resize(img, smallw, smallh, imaging.Box)
+	img = imaging.Resize(img, smallw*block, smallh*block, imaging.NearestNeighbor)
+	img = imaging.Crop(img, image.Rect(0, 0, w, h))
+	if verbose {
+		log.Printf("Pixelating with %d pixel blocks : %s", block, boundsChange(before, img.Bounds()))
 	}
+	return img
+}
 
-	sort.Strings(srcfilenames)
+//ScanlineImage darkens every other row of img by intensity (0-1) for the look of an old CRT
+//screen. An intensity of 0 is a no-op
+func ScanlineImage(intensity float64, img image.Image, verbose bool) image.Image {
+	if intensity <= 0 {
+		return img
+	}
+	dst := imaging.Clone(img)
+	b := dst.Bounds()
+	for y := 1; y < b.Dy(); y += 2 {
+		row := dst.Pix[y*dst.Stride : y*dst.Stride+4*b.Dx()]
+		for i := 0; i < len(row); i += 4 {
+			for c := 0; c < 3; c++ {
+				row[i+c] = uint8(float64(row[i+c])*(1-intensity) + 0.5)
+			}
+		}
+	}
+	if verbose {
+		log.Printf("Adding scanlines with intensity %g : %s", intensity, boundsChange(b, dst.Bounds()))
+	}
+	return dst
+}
 
-	var frames []*image.Paletted
+//QuantizeImage converts img into a paletted frame in a single pass using the drawer for
+//dithering. Passing a nil palette uses the Plan9 palette & a nil drawer uses Floyd-Steinberg
+//dithering, the same defaults image/gif uses
+func QuantizeImage(pal color.Palette, drawer draw.Drawer, img image.Image) *image.Paletted {
+	if pal == nil {
+		pal = palette.Plan9
+	}
+	if drawer == nil {
+		drawer = draw.FloydSteinberg
+	}
+	pimg := image.NewPaletted(img.Bounds(), pal)
+	drawer.Draw(pimg, img.Bounds(), img, img.Bounds().Min)
+	return pimg
+}
 
-	for ctr, filename := range srcfilenames {
-		img, err := imaging.Open(filename)
-		if err != nil {
-			log.Printf("Skipping file %s due to error reading it :%s", filename, err)
-			continue
-		}
+//identityOrder returns the frame order 0, 1, ... count-1
+func identityOrder(count int) []int {
+	order := make([]int, count)
+	for j := range order {
+		order[j] = j
+	}
+	return order
+}
 
-		if *verbose {
-			log.Printf("Parsing image %d of %d : %s", ctr, len(srcfilenames), filename)
-		}
+//reverseOrder returns the frame order count-1, ... 1, 0
+func reverseOrder(count int) []int {
+	order := make([]int, count)
+	for j := range order {
+		order[j] = count - 1 - j
+	}
+	return order
+}
 
-		img = CropImage(*cropleft, *croptop, *cropwidth, *cropheight, img, *verbose)
-		img = ScaleImage(*scale, img, *verbose)
-		img = RotateImage(*rotate, img, *verbose)
-		img = FlipImage(*flip, img, *verbose)
+//ReverseName returns the filename for the reversed GIF written alongside filename by
+//-reverseoutput, eg. movie_reverse.gif for movie.gif
+func ReverseName(filename string) string {
+	ext := filepath.Ext(filename)
+	return strings.TrimSuffix(filename, ext) + "_reverse" + ext
+}
 
-		buf := bytes.Buffer{}
-		if err := gif.Encode(&buf, img, nil); err != nil {
-			log.Printf("Skipping file %s due to error in gif encoding:%s", filename, err)
-			continue
-		}
+//RotateOrder returns a frame order for count frames cyclically shifted so that frame n
+//becomes the first frame. n wraps around the number of frames and may be negative to count
+//back from the last frame
+func RotateOrder(n, count int, verbose bool) []int {
+	order := identityOrder(count)
+	if count == 0 {
+		return order
+	}
+	n = n % count
+	if n < 0 {
+		n += count
+	}
+	if n == 0 {
+		return order
+	}
+	if verbose {
+		log.Printf("Rotating frame order to start at frame %d", n)
+	}
+	return append(order[n:], order[:n]...)
+}
 
-		tmpimg, err := gif.Decode(&buf)
-		if err != nil {
-			log.Printf("Skipping file %s due to weird error reading the temporary gif :%s", filename, err)
+//ResampleOrder returns a frame order picking target frames spread evenly over count frames,
+//dropping frames when there are more than target & repeating them when there are fewer. A
+//target of 0 keeps all the frames
+func ResampleOrder(target, count int, verbose bool) []int {
+	if target <= 0 || target == count || count == 0 {
+		return identityOrder(count)
+	}
+	if verbose {
+		log.Printf("Resampling %d frames to %d frames", count, target)
+	}
+	order := make([]int, target)
+	for j := range order {
+		order[j] = j * count / target
+	}
+	return order
+}
+
+//RepeatOrder returns a frame order for count frames which plays each range of repeats the
+//given number of times over before moving on. It is an error if a range is beyond the frames
+func RepeatOrder(repeats []Repeat, count int, verbose bool) ([]int, error) {
+	starts := make(map[int]Repeat)
+	for _, r := range repeats {
+		if r.Last >= count {
+			return nil, fmt.Errorf("frame %d is beyond the last of the %d frames", r.Last, count)
+		}
+		starts[r.First] = r
+	}
+	var order []int
+	for j := 0; j < count; j++ {
+		r, ok := starts[j]
+		if !ok {
+			order = append(order, j)
 			continue
 		}
-		frames = append(frames, tmpimg.(*image.Paletted))
+		if verbose {
+			log.Printf("Repeating frames %d to %d %d times", r.First, r.Last, r.Times)
+		}
+		for t := 0; t < r.Times; t++ {
+			for k := r.First; k <= r.Last; k++ {
+				order = append(order, k)
+			}
+		}
+		j = r.Last
 	}
+	return order, nil
+}
 
-	if *verbose {
-		log.Printf("Parsed all images.. now attemting to create animated GIF %s", *destname)
+//pickFrames returns the frames in the given order. Frames can be repeated or left out
+func pickFrames(order []int, frames []*image.Paletted) []*image.Paletted {
+	picked := make([]*image.Paletted, len(order))
+	for j, idx := range order {
+		picked[j] = frames[idx]
 	}
+	return picked
+}
 
-	delays := make([]int, len(frames))
-	for j, _ := range delays {
-		delays[j] = *delay
+//pickStrings returns the strings in the given order. Strings can be repeated or left out
+func pickStrings(order []int, strs []string) []string {
+	picked := make([]string, len(order))
+	for j, idx := range order {
+		picked[j] = strs[idx]
 	}
+	return picked
+}
+
+//pickInts returns the ints in the given order. Ints can be repeated or left out
+func pickInts(order []int, ints []int) []int {
+	picked := make([]int, len(order))
+	for j, idx := range order {
+		picked[j] = ints[idx]
+	}
+	return picked
+}
+
+//FilterFilenames keeps the filenames whose base name matches the match expression & does
+//not match the exclude expression. Either expression may be nil to skip that check
+func FilterFilenames(match, exclude *regexp.Regexp, filenames []string) []string {
+	var kept []string
+	for _, filename := range filenames {
+		base := filepath.Base(filename)
+		if match != nil && !match.MatchString(base) {
+			continue
+		}
+		if exclude != nil && exclude.MatchString(base) {
+			continue
+		}
+		kept = append(kept, filename)
+	}
+	return kept
+}
+
+//imageExtensions are the extensions of the image formats that can be decoded
+var imageExtensions = map[string]bool{".gif": true, ".jpeg": true, ".jpg": true, ".png": true}
+
+//ListImages returns the image files in dir, including those in its subdirectories if
+//recursive is set. Files are listed in lexical order within each directory
+func ListImages(dir string, recursive bool) ([]string, error) {
+	var filenames []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if imageExtensions[strings.ToLower(filepath.Ext(path))] {
+			filenames = append(filenames, path)
+		}
+		return nil
+	})
+	return filenames, err
+}
+
+//SharedPalette returns the palette every frame uses or nil if the frames use different
+//palettes
+func SharedPalette(frames []*image.Paletted) color.Palette {
+	if len(frames) == 0 {
+		return nil
+	}
+	pal := frames[0].Palette
+	for _, frame := range frames[1:] {
+		if len(frame.Palette) != len(pal) {
+			return nil
+		}
+		for j, c := range frame.Palette {
+			r1, g1, b1, a1 := c.RGBA()
+			r2, g2, b2, a2 := pal[j].RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+				return nil
+			}
+		}
+	}
+	return pal
+}
+
+//EncodeGIF writes frames as an animated GIF. A loopcount of 0 loops forever while -1 plays
+//the animation once. With globaltable, frames sharing one palette have it written once as
+//the global colour table rather than as a local colour table in every frame
+func EncodeGIF(w io.Writer, frames []*image.Paletted, delays []int, loopcount int, globaltable bool) error {
+	//Frames can differ in size (eg. when trimmed individually) so size the logical screen to
+	//fit the largest rather than letting image/gif default to the first frame
+	var screen image.Rectangle
+	for _, frame := range frames {
+		screen = screen.Union(frame.Bounds())
+	}
+
+	config := image.Config{Width: screen.Max.X, Height: screen.Max.Y}
+	if pal := SharedPalette(frames); globaltable && pal != nil {
+		config.ColorModel = pal
+	}
+	return gif.EncodeAll(w, &gif.GIF{Image: frames, Delay: delays, LoopCount: loopcount, Config: config})
+}
+
+//repeatDelays returns a delays slice for count frames which repeats pattern as many times
+//as needed. A single delay gives each frame the same delay
+func repeatDelays(count int, pattern []int) []int {
+	delays := make([]int, count)
+	for j, _ := range delays {
+		delays[j] = pattern[j%len(pattern)]
+	}
+	return delays
+}
+
+//SpreadDelays shares out delays[o] over the frames whose origin is o so that frames made by
+//-interpolate take up the same time as the frame they were made from. Shares are never made
+//shorter than 1 unless the delay being shared is 0
+func SpreadDelays(delays []int, origin []int) []int {
+	counts := make(map[int]int)
+	for _, o := range origin {
+		counts[o]++
+	}
+	seen := make(map[int]int)
+	spread := make([]int, len(origin))
+	for j, o := range origin {
+		n, k := counts[o], seen[o]
+		spread[j] = delays[o]*(k+1)/n - delays[o]*k/n
+		if spread[j] < 1 && delays[o] > 0 {
+			spread[j] = 1
+		}
+		seen[o]++
+	}
+	return spread
+}
+
+//ResampleDelays returns the delays of the target frames ResampleOrder picks from frames
+//with delays so that the animation takes the same time. Each frame picked plays for the
+//share of the time of the frames it stands in for, which is more than its own delay when
+//frames are dropped & less when it is repeated. Shares are never made shorter than 1
+//unless the time shared is 0
+func ResampleDelays(target int, delays []int) []int {
+	count := len(delays)
+	if target <= 0 || target == count || count == 0 {
+		return delays
+	}
+	//elapsed returns the time played up to p/target of the way through the frames
+	prefix := make([]int, count+1)
+	for j, d := range delays {
+		prefix[j+1] = prefix[j] + d
+	}
+	elapsed := func(p int) int {
+		frame := p / target
+		if frame >= count {
+			return prefix[count]
+		}
+		return prefix[frame] + delays[frame]*(p%target)/target
+	}
+	resampled := make([]int, target)
+	for j := range resampled {
+		resampled[j] = elapsed((j+1)*count) - elapsed(j*count)
+		if resampled[j] < 1 && delays[j*count/target] > 0 {
+			resampled[j] = 1
+		}
+	}
+	return resampled
+}
+
+//maxDelay is the longest delay a GIF frame can have in hundredths of a second
+const maxDelay = 65535
+
+//ScaleDelays multiplies every delay by speed so 0.5 plays twice as fast & 2 at half speed.
+//Delays are rounded to whole hundredths of a second & kept between 1 & maxDelay
+func ScaleDelays(speed float64, delays []int) []int {
+	if speed == 1 {
+		return delays
+	}
+	for j := range delays {
+		delays[j] = int(math.Min(maxDelay, math.Max(1, float64(delays[j])*speed+0.5)))
+	}
+	return delays
+}
+
+//JitterDelays varies every delay at random by up to percent of itself either way for timing
+//that feels less mechanical. The same seed always gives the same variation. Delays are
+//rounded to whole hundredths of a second, never made shorter than 1 & never longer than a
+//GIF can hold
+func JitterDelays(percent float64, seed int64, delays []int) []int {
+	if percent <= 0 {
+		return delays
+	}
+	rng := rand.New(rand.NewSource(seed))
+	for j := range delays {
+		d := float64(delays[j]) * (1 + percent/100*(2*rng.Float64()-1))
+		delays[j] = int(math.Min(maxDelay, math.Max(1, d+0.5)))
+	}
+	return delays
+}
+
+//CapDuration returns how many of the frames with delays fit in seconds, stopping at the
+//first frame which would take the total past it. The first frame is always kept
+func CapDuration(seconds float64, delays []int) int {
+	total := 0
+	for j, d := range delays {
+		total += d
+		if j > 0 && float64(total) > seconds*100 {
+			return j
+		}
+	}
+	return len(delays)
+}
+
+//minBrowserDelay is the shortest delay browsers play as given. Shorter delays are played as
+//10 hundredths of a second instead
+const minBrowserDelay = 2
+
+//ClampDelays raises every delay below min up to min & returns how many were raised
+func ClampDelays(min int, delays []int) int {
+	clamped := 0
+	for j := range delays {
+		if delays[j] < min {
+			delays[j] = min
+			clamped++
+		}
+	}
+	return clamped
+}
 
-	opfile, err := os.Create(*destname)
+//stdin holds the image decoded from standard input for a filename of "-". Standard input
+//can only be read once but the image may be opened more than once
+var stdin struct {
+	once sync.Once
+	img  image.Image
+	err  error
+}
+
+//OpenImage opens & decodes an image file, retrying up to retries more times with a short
+//increasing backoff to ride out transient failures on network filesystems. A filename of
+//"-" reads a single image from standard input
+func OpenImage(filename string, retries int, verbose bool) (image.Image, error) {
+	if filename == "-" {
+		stdin.once.Do(func() {
+			stdin.img, stdin.err = imaging.Decode(os.Stdin)
+		})
+		return stdin.img, stdin.err
+	}
+	img, err := imaging.Open(filename)
+	for attempt := 1; err != nil && attempt <= retries; attempt++ {
+		if verbose {
+			log.Printf("Retrying %s in %s (attempt %d of %d) after error :%s", filename, time.Duration(attempt)*retryBackoff, attempt, retries, err)
+		}
+		time.Sleep(time.Duration(attempt) * retryBackoff)
+		img, err = imaging.Open(filename)
+	}
+	return img, err
+}
+
+//NormalizeImage converts img to NRGBA. Decoders return a variety of colour models (YCbCr,
+//CMYK, Gray, Paletted etc) so this makes all downstream operations & the gif encoder see the
+//same thing
+func NormalizeImage(img image.Image) *image.NRGBA {
+	return imaging.Clone(img)
+}
+
+//SizeCounts reads just the headers of filenames & counts how many images there are of each
+//size. Files whose header can't be read are left out
+func SizeCounts(filenames []string) map[image.Point]int {
+	counts := make(map[image.Point]int)
+	for _, filename := range filenames {
+		f, err := os.Open(filename)
+		if err != nil {
+			continue
+		}
+		cfg, _, err := image.DecodeConfig(f)
+		f.Close()
+		if err == nil {
+			counts[image.Pt(cfg.Width, cfg.Height)]++
+		}
+	}
+	return counts
+}
+
+//StandardizeImage centers img on a transparent canvas of size, padding smaller images &
+//cropping larger ones. Images already of that size are left alone
+func StandardizeImage(size image.Point, img image.Image, verbose bool) image.Image {
+	before := img.Bounds()
+	if before.Size() == size {
+		return img
+	}
+	img = imaging.PasteCenter(imaging.New(size.X, size.Y, color.Transparent), img)
+	if verbose {
+		log.Printf("Standardizing image to %dx%d : %s", size.X, size.Y, boundsChange(before, img.Bounds()))
+	}
+	return img
+}
+
+//otherFormats are the extensions of image & video formats other than GIF
+var otherFormats = map[string]bool{".apng": true, ".avif": true, ".jpeg": true, ".jpg": true, ".mp4": true, ".png": true, ".webm": true, ".webp": true}
+
+//noloopHold is the least time in hundredths of a second the last frame is shown for with
+//-noloop
+const noloopHold = 1000
+
+//retryBackoff is the wait before the first retry of a failed image open. Each further
+//retry waits that much longer
+const retryBackoff = 200 * time.Millisecond
+
+//forEach calls fn for every index in [0, count) using a pool of threads workers. Each index
+//is handled exactly once but in no particular order. Once stop is signalled no further
+//indexes are handed out. It waits for the workers & returns n such that all of [0, n) were
+//handled
+func forEach(threads, count int, stop <-chan struct{}, fn func(i int)) int {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < threads; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+
+	dispatched := 0
+dispatch:
+	for dispatched < count {
+		select {
+		case <-stop:
+			break dispatch
+		case jobs <- dispatched:
+			dispatched++
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return dispatched
+}
+
+func main() {
+
+	srcglob := flag.String("src", "*.jpg", "a glob pattern or directory of source images or - to read one image from standard input. defaults to *.jpg")
+	destname := flag.String("dest", "movie.gif", "a destination filename for the animated gif or a comma separated list of filenames")
+	cropleft := flag.Int("cropleft", 0, "left co-ordinate for crop to start")
+	croptop := flag.Int("croptop", 0, "top co-ordinate for crop to start")
+	cropwidth := flag.Int("cropwidth", -1, "width of cropped image, -1 specifies full width")
+	cropheight := flag.Int("cropheight", -1, "height of cropped image, -1 specified full height")
+	cropgrid := flag.String("cropgrid", "", "split each image into a grid of rows x columns tiles like 4x8 with each tile a frame")
+	cropaspect := flag.String("cropaspect", "", "center crop images to the largest rectangle of an aspect ratio like 16:9 or 1:1")
+	cropfollow := flag.String("cropfollow", "", "optional Haar cascade file used to pan a fixed size crop to keep the detected subject centered")
+	croppathfile := flag.String("croppath", "", "optional file of left,top crop offsets, one line per image, to pan a fixed size crop")
+	dpi := flag.Float64("dpi", 0, "dots per inch of the source images so -crop can be given in inches or millimetres like 1in or 25mm")
+	cropspec := flag.String("crop", "", "crop rectangle as left,top,right,bottom or left,top,WxH instead of the individual crop flags")
+	delayspec := flag.String("delay", "3", "delay time between frame in hundredths of a second or a comma separated list repeated over the frames")
+	jitter := flag.Float64("jitter", 0, "randomly vary each frame delay by up to this percentage either way, 0 disables it")
+	maxduration := flag.Float64("maxduration", 0, "drop the frames after this many seconds of playing time, 0 for no limit")
+	seed := flag.Int64("seed", 0, "seed for the random variation of -jitter, 0 picks a new seed every run")
+	speed := flag.Float64("speed", 1.0, "multiplies every frame delay, 0.5 plays twice as fast & 2 at half speed")
+	verbose := flag.Bool("verbose", false, "show in-process messages")
+	scalespec := flag.String("scale", "1", "scaling factor to apply if any, either like 0.5 or like 50%")
+	docrop := flag.Bool("docrop", true, "apply the crop operation, false skips it whatever the crop flags are")
+	doscale := flag.Bool("doscale", true, "apply the scale operation, false skips it whatever the scale flags are")
+	dorotate := flag.Bool("dorotate", true, "apply the rotate operation, false skips it whatever the rotate flag is")
+	doflip := flag.Bool("doflip", true, "apply the flip operation, false skips it whatever the flip flag is")
+	orderspec := flag.String("order", "crop,scale,rotate,flip", "order to apply the crop, scale, rotate & flip operations in")
+	scalestart := flag.Float64("scalestart", 0, "scaling factor for the first image of a zoom, used with -scaleend instead of -scale")
+	scaleend := flag.Float64("scaleend", 0, "scaling factor for the last image of a zoom, used with -scalestart instead of -scale")
+	noupscale := flag.Bool("noupscale", false, "never enlarge images, scale factors above 1 are treated as 1")
+	linearresize := flag.Bool("linearresize", false, "resize in linear light instead of sRGB colour space")
+	rotateexpand := flag.Bool("rotateexpand", false, "enlarge the canvas of -rotateauto & -spin frames to fit the whole rotated image instead of clipping it")
+	rotateauto := flag.Bool("rotateauto", false, "level tilted horizons by detecting & undoing a tilt of up to 15 degrees in each frame")
+	rotatespec := flag.String("rotate", "0", "valid values are 0, 90, 180, 270 or cw (90), ccw (270), flip (180)")
+	flip := flag.String("flip", "none", "valid falues are none, horizontal, vertical")
+	fitanchor := flag.String("fitanchor", "center", "where frames sit on a square-N preset canvas, eg. center, top, bottom, left, right, topleft")
+	presetname := flag.String("preset", "", "output size preset, one of 240p, 360p, 480p, 720p, 1080p or square-N for an N x N canvas")
+	autowb := flag.String("autowb", "none", "gray world white balance to remove colour casts, valid values are none, frame, global")
+	autolevels := flag.String("autolevels", "none", "stretch contrast to the full range, valid values are none, frame, global")
+	accumulate := flag.Bool("accumulate", false, "composite each frame with all the frames before it for a light trails effect")
+	accumulatemode := flag.String("accumulatemode", "max", "how frames are accumulated, valid values are max, lighten, add")
+	mirror := flag.Bool("mirror", false, "make frames symmetric by reflecting one half onto the other")
+	mirroraxis := flag.String("mirroraxis", "vertical", "line -mirror reflects across, valid values are vertical, horizontal, both, none")
+	motionblur := flag.Bool("motionblur", false, "blend each frame with the frames before it to simulate motion blur")
+	motionblurstrength := flag.Float64("motionblurstrength", 0.5, "weight (0-1) given to the preceding frames in motion blur, 0 disables it")
+	overlap := flag.Bool("overlap", false, "smooth motion by ghosting part of the next frame into each frame without adding frames")
+	overlapamount := flag.Float64("overlapamount", 0.3, "weight (0-1) given to the next frame by -overlap, 0 disables it")
+	autodelay := flag.Bool("autodelay", false, "reproduce the original playback speed from the -timestamps file or the times in file names")
+	timestampfile := flag.String("timestamps", "", "optional file of frame times in milliseconds, one per line, like ffmpeg -f mkvtimestamp_v2 writes, for -autodelay")
+	parsetiming := flag.Bool("parsetiming", false, "use capture times in file names like frame_001523ms.jpg to time the frames")
+	denoise := flag.Bool("denoise", false, "reduce sensor noise & grain with a median filter which also makes GIFs smaller")
+	denoisestrength := flag.Int("denoisestrength", 1, "radius in pixels of the -denoise filter, 0 disables it")
+	pixelate := flag.Int("pixelate", 0, "block size in pixels for a mosaic effect, 0 or 1 disables it")
+	halftone := flag.Bool("halftone", false, "render frames as a black & white halftone dot pattern")
+	halftonedotsize := flag.Int("halftonedotsize", 8, "spacing of halftone dots in pixels, 0 disables halftone")
+	scanlines := flag.Bool("scanlines", false, "darken every other row of pixels for a retro CRT look")
+	scanlineintensity := flag.Float64("scanlineintensity", 0.5, "how much (0-1) -scanlines darkens its rows, 0 disables it")
+	sidecartext := flag.Bool("sidecartext", false, "draw the text in foo.txt onto the frame made from foo.jpg where such a file exists")
+	backgroundhex := flag.String("background", "#000000", "hex colour that transparent images are flattened onto")
+	dither := flag.String("dither", "floydsteinberg", "valid values are floydsteinberg, bayer, none")
+	skipunchanged := flag.Bool("skipunchanged", false, "do nothing if the -hashmanifest file shows the sources & settings are unchanged")
+	skipsolid := flag.Bool("skipsolid", false, "drop frames which are a single solid colour such as black frames at scene cuts")
+	skipsolidtolerance := flag.Int("skipsolidtolerance", 8, "how far (0-255) pixels can differ & still count as one solid colour for -skipsolid")
+	smartdither := flag.Bool("smartdither", false, "skip dithering for simple frames with few colours such as screen captures")
+	smartditherthreshold := flag.Int("smartditherthreshold", 256, "frames with at most this many colours are not dithered under -smartdither")
+	dumppalette := flag.String("dumppalette", "", "optional filename to write the GIF palette to as a png swatch or a text list of hex colours")
+	adaptivecolors := flag.Bool("adaptivecolors", false, "give each frame only as many colours as it uses, up to 256, for smaller GIFs")
+	colortable := flag.String("colortable", "auto", "where frame palettes are written, valid values are auto, global, local")
+	quality := flag.Int("quality", 0, "1 (fast & small) to 10 (best) choosing the palette & dithering together, 0 uses the individual flags")
+	palettename := flag.String("palette", "plan9", "fixed palette for all frames unless -palettefile is given, valid values are plan9, websafe")
+	palettefile := flag.String("palettefile", "", "optional file of 2-256 hex colours to use as a fixed palette for all frames")
+	htmlpreview := flag.String("htmlpreview", "", "optional HTML file to write showing the GIF with a summary & the parameters used")
+	hashmanifest := flag.String("hashmanifest", "", "optional file recording the hash of each source image & the settings used")
+	interlace := flag.Bool("interlace", false, "write interlaced frames which viewers can show progressively while loading")
+	interpolate := flag.Bool("interpolate", false, "smooth choppy sequences by blending in-between frames keeping the same duration")
+	interpolatefactor := flag.Int("interpolatefactor", 1, "number of frames -interpolate inserts between each pair of frames")
+	livepreview := flag.Int("livepreview", 0, "rewrite the destination with the frames done so far every this many frames, 0 disables it")
+	lutfile := flag.String("lut", "", "optional .cube 3D LUT file to colour grade the frames with")
+	matchexpr := flag.String("match", "", "only use source files whose name matches this regular expression")
+	exiftransforms := flag.Bool("exiftransforms", false, "apply crop & rotate hints embedded in the EXIF or comments of JPEG images")
+	excludeexpr := flag.String("exclude", "", "skip source files whose name matches this regular expression")
+	retry := flag.Int("retry", 0, "number of times to retry reading an image that fails to open before skipping it")
+	loopdelay := flag.Int("loopdelay", 0, "extra delay in hundredths of a second added to the last frame to pause before looping")
+	mkdir := flag.Bool("mkdir", false, "create the directories of -dest if they don't exist")
+	minbrowserdelay := flag.Bool("minbrowserdelay", false, "raise delays below 2 to 2 since browsers play them much slower as 10")
+	reverseoutput := flag.Bool("reverseoutput", false, "also write the frames in reverse to a second GIF named like the destination with _reverse added")
+	noloop := flag.Bool("noloop", false, "play the animation once & hold the last frame instead of looping forever")
+	recursive := flag.Bool("recursive", false, "also use images in subdirectories when -src is a directory")
+	nosort := flag.Bool("nosort", false, "keep the order images are found in instead of sorting them alphabetically")
+	clipspec := flag.String("clip", "", "select a section by time with a spec like start=2s,end=6s,fps=15")
+	trimframes := flag.Bool("trimframes", false, "drop the still frames at the start & end before & after the motion")
+	stabilizepalette := flag.Bool("stabilizepalette", false, "match the colours picked for each frame to those of the frame before to stop them shimmering")
+	stabilizetolerance := flag.Int("stabilizetolerance", 8, "how far (0-255) colours can differ & still be matched under -stabilizepalette")
+	trimframestolerance := flag.Int("trimframestolerance", 8, "how far (0-255) pixels can differ & still count as the same under -trimframes")
+	trim := flag.Bool("trim", false, "automatically crop away uniform colour borders from each image")
+	trimtolerance := flag.Int("trimtolerance", 0, "how far (0-255) a pixel can differ from the border colour & still be trimmed")
+	standardize := flag.Bool("standardize", false, "pad or crop all images to the most common image size")
+	trimuniform := flag.Bool("trimuniform", false, "trim all images by the borders found in the first image")
+	maxbytes := flag.Int("maxbytes", 0, "shrink colours & then frame size until the GIF is at most this many bytes, 0 for no limit")
+	spin := flag.Float64("spin", 0, "rotate the frames by an increasing angle adding up to this many degrees over the animation")
+	spindirection := flag.String("spindirection", "cw", "direction of -spin, valid values are cw, ccw")
+	comment := flag.String("comment", "", "optional text to embed in the GIF as a comment")
+	creditcomment := flag.Bool("credit", false, "embed a created by goanigiffy comment in the GIF")
+	fuse := flag.String("fuse", "", "optional png or jpg filename to merge bracketed exposures into one still instead of writing a GIF")
+	poster := flag.String("poster", "", "optional filename to also save the first frame as a png or jpg poster image")
+	targetframes := flag.Int("targetframes", 0, "drop or repeat frames evenly to give exactly this many frames in the same time, 0 keeps all frames")
+	spritesheet := flag.String("spritesheet", "", "optional png or jpg filename to also save all frames as a sprite sheet with a css animation")
+	spritesheetcols := flag.Int("spritesheetcols", 0, "number of columns in the -spritesheet grid, 0 puts all frames in one row")
+	thumb := flag.String("thumb", "", "optional filename to also save a small png or jpg thumbnail of one frame")
+	thumbindex := flag.Int("thumbindex", 0, "frame number to use for the thumbnail")
+	thumbsize := flag.Int("thumbsize", 160, "maximum width & height of the thumbnail")
+	timingcsv := flag.String("timingcsv", "", "optional csv file of the microseconds spent on each image in each stage, a row per frame of an animated GIF & per -cropgrid tile")
+	timingfile := flag.String("timingfile", "", "optional .srt or .vtt subtitle file listing each frame's time & source image")
+	repeatspec := flag.String("repeat", "", "frames or ranges to play more than once like 3-5x2,8x3 which plays frames 3 to 5 twice & frame 8 three times")
+	rotateframes := flag.Int("rotateframes", 0, "cyclically shift frame order so this frame number comes first")
+	//-threads only sizes our own worker pools. The Go runtime is left to its defaults which
+	//respect the GOMAXPROCS environment variable
+	threads := flag.Int("threads", runtime.GOMAXPROCS(0), "number of images to process in parallel, 1 processes serially")
+	timing := flag.Bool("timing", false, "report the time spent decoding, in each operation, quantizing & encoding")
+	verify := flag.Bool("verify", false, "re-read the written GIF to check it is complete")
+	loadrecipe := flag.String("loadrecipe", "", "optional JSON recipe file of settings to use, flags on the command line take precedence")
+	saverecipe := flag.String("saverecipe", "", "optional JSON recipe file to save the settings used to")
+	showversion := flag.Bool("version", false, "print version information and exit")
+
+	flag.Parse()
+
+	if *showversion {
+		fmt.Printf("goanigiffy %s (commit %s, built %s, %s)\n", version, commit, builddate, runtime.Version())
+		os.Exit(0)
+	}
+
+	//A recipe fills in the flags not given on the command line before any are checked
+	if *loadrecipe != "" {
+		if err := LoadRecipe(*loadrecipe); err != nil {
+			log.Fatalf("Error loading recipe %s : %s", *loadrecipe, err)
+		}
+		if *verbose {
+			log.Printf("Loaded settings from recipe %s", *loadrecipe)
+		}
+	}
+
+	scale, err := ParseScale(*scalespec)
 	if err != nil {
-		log.Fatalf("Error creating the destination file %s : %s", *destname, err)
+		log.Printf("scale flag is invalid : %s", err)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	zoom := *scalestart != 0 || *scaleend != 0
+	if zoom && (*scalestart <= 0 || *scaleend <= 0) {
+		log.Printf("scalestart & scaleend flags must both be greater than 0 for a zoom")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	//Scale is relative to each image so clamping the factor is enough to never enlarge.
+	//Presets only ever shrink images anyway
+	if *noupscale && scale > 1 {
+		if *verbose {
+			log.Printf("Ignoring scale %g since -noupscale is set", scale)
+		}
+		scale = 1
+	}
+	if *noupscale && zoom {
+		*scalestart = math.Min(*scalestart, 1)
+		*scaleend = math.Min(*scaleend, 1)
+	}
+
+	if *thumbsize < 1 {
+		log.Printf("thumbsize flag must be 1 or more")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *livepreview < 0 {
+		log.Printf("livepreview flag must be 0 or more")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	//-fuse writes a single still instead of the GIF so there is nothing to preview
+	if *livepreview > 0 && *fuse != "" {
+		log.Printf("livepreview flag can't be used with -fuse")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *maxbytes < 0 {
+		log.Printf("maxbytes flag must be 0 or more")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *retry < 0 {
+		log.Printf("retry flag must be 0 or more")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *speed <= 0 {
+		log.Printf("speed flag must be greater than 0")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *jitter < 0 || *jitter > 100 {
+		log.Printf("jitter flag must be between 0 and 100")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if *maxduration < 0 {
+		log.Printf("maxduration flag must be 0 or more")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *threads < 1 {
+		log.Printf("threads flag must be 1 or more")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	//Only GIFs can be encoded so destinations named for other formats are refused rather
+	//than written as a GIF with the wrong extension
+	var destnames []string
+	for _, dest := range strings.Split(*destname, ",") {
+		dest = strings.TrimSpace(dest)
+		if otherFormats[strings.ToLower(filepath.Ext(dest))] {
+			log.Printf("dest flag has %q but only animated GIFs can be written", dest)
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		destnames = append(destnames, dest)
+	}
+
+	rotate, ok := rotateNames[*rotatespec]
+	if !ok {
+		log.Printf("rotate flag must be one of 0, 90, 180, 270, cw, ccw or flip")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	anchornames := map[string]imaging.Anchor{"center": imaging.Center, "top": imaging.Top, "bottom": imaging.Bottom,
+		"left": imaging.Left, "right": imaging.Right, "topleft": imaging.TopLeft, "topright": imaging.TopRight,
+		"bottomleft": imaging.BottomLeft, "bottomright": imaging.BottomRight}
+	anchor, ok := anchornames[*fitanchor]
+	if !ok {
+		log.Printf("fitanchor flag must be one of center, top, bottom, left, right, topleft, topright, bottomleft or bottomright")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if !(*flip == "none" || *flip == "horizontal" || *flip == "vertical") {
+		log.Printf("flip flag must be one of none, horizontal or vertical")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if !(*autowb == "none" || *autowb == "frame" || *autowb == "global") {
+		log.Printf("autowb flag must be one of none, frame or global")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if !(*autolevels == "none" || *autolevels == "frame" || *autolevels == "global") {
+		log.Printf("autolevels flag must be one of none, frame or global")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if !(*accumulatemode == "max" || *accumulatemode == "lighten" || *accumulatemode == "add") {
+		log.Printf("accumulatemode flag must be one of max, lighten or add")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *motionblurstrength < 0 || *motionblurstrength >= 1 {
+		log.Printf("motionblurstrength flag must be at least 0 and less than 1")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *overlapamount < 0 || *overlapamount > 1 {
+		log.Printf("overlapamount flag must be between 0 and 1")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if !(*mirroraxis == "vertical" || *mirroraxis == "horizontal" || *mirroraxis == "both" || *mirroraxis == "none") {
+		log.Printf("mirroraxis flag must be one of vertical, horizontal, both or none")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if !(*spindirection == "cw" || *spindirection == "ccw") {
+		log.Printf("spindirection flag must be one of cw or ccw")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *interpolatefactor < 0 {
+		log.Printf("interpolatefactor flag must be 0 or more")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *scanlineintensity < 0 || *scanlineintensity > 1 {
+		log.Printf("scanlineintensity flag must be between 0 and 1")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *halftonedotsize < 0 {
+		log.Printf("halftonedotsize flag must be 0 or more")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *loopdelay < 0 {
+		log.Printf("loopdelay flag must be 0 or more")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *spritesheetcols < 0 {
+		log.Printf("spritesheetcols flag must be 0 or more")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *denoisestrength < 0 {
+		log.Printf("denoisestrength flag must be 0 or more")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *pixelate < 0 {
+		log.Printf("pixelate flag must be 0 or more")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *targetframes < 0 {
+		log.Printf("targetframes flag must be 0 or more")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *skipsolidtolerance < 0 || *skipsolidtolerance > 255 {
+		log.Printf("skipsolidtolerance flag must be between 0 and 255")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *trimframestolerance < 0 || *trimframestolerance > 255 {
+		log.Printf("trimframestolerance flag must be between 0 and 255")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *stabilizetolerance < 0 || *stabilizetolerance > 255 {
+		log.Printf("stabilizetolerance flag must be between 0 and 255")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *trimtolerance < 0 || *trimtolerance > 255 {
+		log.Printf("trimtolerance flag must be between 0 and 255")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if *dpi < 0 {
+		log.Printf("dpi flag must be 0 or more")
+		flag.PrintDefaults()
+		os.Exit(1)
 	}
 
-	if err := gif.EncodeAll(opfile, &gif.GIF{Image: frames, Delay: delays, LoopCount: 0}); err != nil {
-		log.Printf("Error encoding output into animated gif :%s", err)
+	if *cropspec != "" {
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "cropleft" || f.Name == "croptop" || f.Name == "cropwidth" || f.Name == "cropheight" {
+				log.Printf("crop flag cannot be combined with the %s flag", f.Name)
+				flag.PrintDefaults()
+				os.Exit(1)
+			}
+		})
+		var err error
+		if *cropleft, *croptop, *cropwidth, *cropheight, err = ParseCrop(*cropspec, *dpi); err != nil {
+			log.Printf("crop flag is invalid : %s", err)
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+	}
+
+	var lut *LUT
+	if *lutfile != "" {
+		var err error
+		if lut, err = LoadCube(*lutfile); err != nil {
+			log.Fatalf("Error loading LUT %s : %s", *lutfile, err)
+		}
+		if *verbose {
+			log.Printf("Loaded %d point LUT from %s", lut.Size, *lutfile)
+		}
+	}
+
+	var aspectwidth, aspectheight int
+	if *cropaspect != "" {
+		var err error
+		if aspectwidth, aspectheight, err = ParseAspect(*cropaspect); err != nil {
+			log.Printf("cropaspect flag is invalid : %s", err)
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+	}
+
+	var gridrows, gridcols int
+	if *cropgrid != "" {
+		var err error
+		if gridrows, gridcols, err = ParseGrid(*cropgrid); err != nil {
+			log.Printf("cropgrid flag is invalid : %s", err)
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+	}
+
+	var croppath []image.Point
+	if *croppathfile != "" {
+		if *cropwidth == -1 || *cropheight == -1 {
+			log.Printf("croppath flag needs the crop size set with cropwidth & cropheight")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		var err error
+		if croppath, err = LoadCropPath(*croppathfile); err != nil {
+			log.Fatalf("Error loading crop path %s : %s", *croppathfile, err)
+		}
+		if *verbose {
+			log.Printf("Loaded %d crop offsets from %s", len(croppath), *croppathfile)
+		}
+	}
+
+	//-cropfollow works out a crop path once the source images are known. The detector is
+	//loaded now so a build without detection fails before any work is done
+	var detector SubjectDetector
+	if *cropfollow != "" {
+		if *cropwidth == -1 || *cropheight == -1 {
+			log.Printf("cropfollow flag needs the crop size set with cropwidth & cropheight")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		if *croppathfile != "" || *cropgrid != "" {
+			log.Printf("cropfollow flag cannot be combined with the croppath or cropgrid flags")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		var err error
+		if detector, err = NewSubjectDetector(*cropfollow); err != nil {
+			log.Fatalf("Error loading subject detector %s : %s", *cropfollow, err)
+		}
+		defer detector.Close()
+	}
+
+	operations, err := ParseOrder(*orderspec)
+	if err != nil {
+		log.Printf("order flag is invalid : %s", err)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	//Operations switched off with the -do flags are skipped whatever their parameters are
+	enabled := map[string]bool{"crop": *docrop, "scale": *doscale, "rotate": *dorotate, "flip": *doflip}
+	var enabledops []string
+	for _, op := range operations {
+		if enabled[op] {
+			enabledops = append(enabledops, op)
+		} else if *verbose {
+			log.Printf("Skipping the %s operation since -do%s is false", op, op)
+		}
+	}
+	operations = enabledops
+
+	//The subject is found in the source images as they are read so the crop has to see them
+	//unchanged for the path to line up
+	if *cropfollow != "" {
+		if len(operations) == 0 || operations[0] != "crop" {
+			log.Printf("cropfollow flag needs crop to be the first operation of -order")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		if *standardize || *trim || *trimuniform || *exiftransforms {
+			log.Printf("cropfollow flag cannot be combined with the standardize, trim, trimuniform or exiftransforms flags")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+	}
+
+	var repeats []Repeat
+	if *repeatspec != "" {
+		var err error
+		if repeats, err = ParseRepeats(*repeatspec); err != nil {
+			log.Printf("repeat flag is invalid : %s", err)
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+	}
+
+	var preset Preset
+	if *presetname != "" {
+		var err error
+		if preset, err = ParsePreset(*presetname); err != nil {
+			log.Printf("preset flag is invalid : %s", err)
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+	}
+
+	delay, err := ParseDelays(*delayspec)
+	if err != nil {
+		log.Printf("delay flag is invalid : %s", err)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	delayset := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "delay" {
+			delayset = true
+		}
+	})
+
+	var clip Clip
+	if *clipspec != "" {
+		var err error
+		if clip, err = ParseClip(*clipspec); err != nil {
+			log.Printf("clip flag is invalid : %s", err)
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		//Playback at the clip's frame rate replaces -delay
+		if clip.FPS != 0 {
+			delay = []int{int(100/clip.FPS + 0.5)}
+			if delay[0] < 1 {
+				delay[0] = 1
+			}
+		}
+	}
+
+	background, err := ParseHexColor(*backgroundhex)
+	if err != nil {
+		log.Printf("background flag is invalid : %s", err)
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	ditherer := Dithering(*dither)
+	if ditherer == nil {
+		log.Printf("dither flag must be one of floydsteinberg, bayer or none")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if !(*colortable == "auto" || *colortable == "global" || *colortable == "local") {
+		log.Printf("colortable flag must be one of auto, global or local")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	globaltable := *colortable != "local"
+
+	var matchre, excludere *regexp.Regexp
+	if *matchexpr != "" {
+		if matchre, err = regexp.Compile(*matchexpr); err != nil {
+			log.Printf("match flag is not a valid regular expression : %s", err)
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+	}
+	if *excludeexpr != "" {
+		if excludere, err = regexp.Compile(*excludeexpr); err != nil {
+			log.Printf("exclude flag is not a valid regular expression : %s", err)
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+	}
+
+	if *smartditherthreshold < 1 {
+		log.Printf("smartditherthreshold flag must be 1 or more")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	//-quality picks the palette & dithering in one go but flags given explicitly still win
+	var pal color.Palette
+	var quantcolors int
+	if *quality != 0 {
+		if *quality < 1 || *quality >= len(qualityLevels) {
+			log.Printf("quality flag must be between 1 and %d", len(qualityLevels)-1)
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		level := qualityLevels[*quality]
+		set := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		if !set["dither"] {
+			ditherer = Dithering(level.dither)
+		}
+		if level.levels > 0 {
+			pal = UniformPalette(level.levels)
+		}
+		quantcolors = level.colors
+		if *verbose {
+			log.Printf("Using quality %d with %s dithering", *quality, level.dither)
+		}
+	}
+	//A fixed palette, either built in or from -palettefile, is used for every frame
+	fixedpalette := *palettefile != "" || *palettename != "plan9"
+	switch *palettename {
+	case "plan9":
+	case "websafe":
+		if *palettefile != "" {
+			log.Printf("palette flag cannot be combined with the palettefile flag")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		pal, quantcolors = palette.WebSafe, 0
+	default:
+		log.Printf("palette flag must be one of plan9 or websafe")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if *palettefile != "" {
+		quantcolors = 0
+		var err error
+		if pal, err = LoadPalette(*palettefile); err != nil {
+			log.Fatalf("Error loading palette file %s : %s", *palettefile, err)
+		}
+		if *verbose {
+			log.Printf("Loaded %d colour palette from %s", len(pal), *palettefile)
+		}
+	}
+
+	//A global colour table needs every frame to share one palette
+	if *colortable == "global" && (quantcolors > 0 || *adaptivecolors && !fixedpalette) {
+		log.Printf("colortable flag cannot be global when -quality 6 to 10 or -adaptivecolors pick a palette for each frame")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	//The recipe is saved once the settings are known to be valid
+	if *saverecipe != "" {
+		if *verbose {
+			log.Printf("Saving settings to recipe %s", *saverecipe)
+		}
+		if err := SaveRecipe(*saverecipe); err != nil {
+			log.Printf("Error saving recipe %s : %s", *saverecipe, err)
+		}
+	}
+
+	//Image files given after the flags take precedence over the -src glob
+	var srcfilenames []string
+	if flag.NArg() > 0 {
+		srcfilenames = flag.Args()
+	} else if *srcglob == "-" {
+		srcfilenames = []string{"-"}
+	} else if info, err := os.Stat(*srcglob); err == nil && info.IsDir() {
+		if srcfilenames, err = ListImages(*srcglob, *recursive); err != nil {
+			log.Fatalf("Error listing images in source directory %s : %s", *srcglob, err)
+		}
+	} else if srcfilenames, err = filepath.Glob(*srcglob); err != nil {
+		log.Fatalf("Error in globbing source file pattern %s : %s", *srcglob, err)
+	}
+
+	if !*nosort {
+		sort.Strings(srcfilenames)
+	}
+
+	//-autodelay with -timestamps pairs each source image with its line of the timestamp file
+	//before -match, -exclude, -clip or anything else changes the list of images
+	var sourcetimes map[string]float64
+	if *autodelay && *timestampfile != "" {
+		times, err := LoadTimestamps(*timestampfile)
+		if err == nil && len(times) != len(srcfilenames) {
+			err = fmt.Errorf("found %d timestamps for %d images", len(times), len(srcfilenames))
+		}
+		if err != nil {
+			log.Printf("Using -delay since the timestamps could not be read from %s : %s", *timestampfile, err)
+		} else {
+			sourcetimes = make(map[string]float64)
+			for j, filename := range srcfilenames {
+				sourcetimes[filename] = times[j]
+			}
+		}
+	}
+
+	if matchre != nil || excludere != nil {
+		globbed := len(srcfilenames)
+		srcfilenames = FilterFilenames(matchre, excludere, srcfilenames)
+		if *verbose {
+			log.Printf("Filtered out %d of %d files via -match & -exclude", globbed-len(srcfilenames), globbed)
+		}
+	}
+
+	if len(srcfilenames) == 0 && flag.NArg() > 0 {
+		log.Fatalf("None of the %d images given on the command line are left after -match & -exclude", flag.NArg())
+	} else if len(srcfilenames) == 0 {
+		log.Fatalf("No source images found via pattern %s", *srcglob)
+	}
+
+	if *verbose {
+		log.Printf("Found %d images to parse", len(srcfilenames))
+	}
+
+	//A single animated GIF as the source is re-timed & edited with each of its frames used as
+	//an image. animframe holds which frame of the GIF each entry is
+	var anim *Animation
+	var animframe []int
+	if len(srcfilenames) == 1 && strings.ToLower(filepath.Ext(srcfilenames[0])) == ".gif" {
+		var err error
+		if anim, err = DecodeAnimation(srcfilenames[0]); err != nil {
+			log.Fatalf("Error reading animated GIF %s : %s", srcfilenames[0], err)
+		}
+		if len(anim.Frames) > 1 {
+			if *verbose {
+				log.Printf("Editing the %d frames of animated GIF %s", len(anim.Frames), srcfilenames[0])
+			}
+			filename := srcfilenames[0]
+			srcfilenames = nil
+			for j := range anim.Frames {
+				srcfilenames = append(srcfilenames, filename)
+				animframe = append(animframe, j)
+			}
+		} else {
+			anim = nil
+		}
+	}
+
+	if *clipspec != "" {
+		fps := clip.FPS
+		if fps == 0 {
+			//a list of delays plays at its average rate. An animated GIF's times are those of
+			//its own delays
+			delays := delay
+			if anim != nil {
+				delays = anim.Delays
+			}
+			total := 0
+			for _, d := range delays {
+				total += d
+			}
+			if total == 0 {
+				log.Fatalf("Clip %s needs an fps since the images are played with no delay", *clipspec)
+			}
+			fps = 100 * float64(len(delays)) / float64(total)
+		}
+		first, last := clip.Frames(fps, len(srcfilenames))
+		if first >= last {
+			log.Fatalf("Clip %s selects none of the %d images at %g fps", *clipspec, len(srcfilenames), fps)
+		}
+		if *verbose {
+			log.Printf("Clip %s selects images %d to %d at %g fps", *clipspec, first, last-1, fps)
+		}
+		srcfilenames = srcfilenames[first:last]
+		if anim != nil {
+			animframe = animframe[first:last]
+		}
+	}
+
+	//With -hashmanifest a record of the source images & settings is kept alongside the GIF
+	//& -skipunchanged stops early if the last run used exactly the same
+	var manifest []byte
+	if *hashmanifest != "" {
+		var err error
+		if manifest, err = BuildManifest(srcfilenames); err != nil {
+			log.Printf("Not writing manifest %s due to error hashing the source images :%s", *hashmanifest, err)
+		} else if *skipunchanged && ManifestUnchanged(*hashmanifest, manifest) {
+			written := true
+			for _, dest := range destnames {
+				if _, err := os.Stat(dest); err != nil {
+					written = false
+				}
+			}
+			if written {
+				log.Printf("Skipping since the source images & settings match manifest %s", *hashmanifest)
+				return
+			}
+		}
+	}
+
+	//Check an explicit crop rectangle fits the images. This only decodes the header of the
+	//first image and the images are expected to share its size
+	if *cropspec != "" && !*trim && !*trimuniform && *cropgrid == "" && len(operations) > 0 && operations[0] == "crop" {
+		if f, err := os.Open(srcfilenames[0]); err == nil {
+			cfg, _, err := image.DecodeConfig(f)
+			f.Close()
+			if err == nil && (*cropleft+*cropwidth > cfg.Width || *croptop+*cropheight > cfg.Height) {
+				log.Fatalf("Crop %s extends beyond the %dx%d size of %s", *cropspec, cfg.Width, cfg.Height, srcfilenames[0])
+			}
+		}
+	}
+
+	//openSource reads source image j, which is a frame already decoded for an animated GIF
+	openSource := func(j int) (image.Image, error) {
+		if anim != nil {
+			return anim.Frames[animframe[j]], nil
+		}
+		return OpenImage(srcfilenames[j], *retry, *verbose)
+	}
+
+	//With -trimuniform a single trim computed from the first image is applied to all so
+	//that the frames stay aligned with each other
+	var uniformtrim image.Rectangle
+	if *trimuniform {
+		img, err := openSource(0)
+		if err != nil {
+			log.Fatalf("Error reading %s to find the uniform trim : %s", srcfilenames[0], err)
+		}
+		uniformtrim = FindTrim(*trimtolerance, img)
+		if *verbose {
+			log.Printf("Trimming all images to (%d,%d)->(%d,%d) found from %s", uniformtrim.Min.X, uniformtrim.Min.Y, uniformtrim.Max.X-1, uniformtrim.Max.Y-1, srcfilenames[0])
+		}
+	}
+
+	//With -standardize the most common image size becomes the canvas for all images. Only
+	//the headers are read here so the images aren't decoded twice
+	var standardsize image.Point
+	if *standardize {
+		counts := SizeCounts(srcfilenames)
+		sizes := make([]image.Point, 0, len(counts))
+		for size := range counts {
+			sizes = append(sizes, size)
+		}
+		sort.Slice(sizes, func(i, j int) bool {
+			if counts[sizes[i]] != counts[sizes[j]] {
+				return counts[sizes[i]] > counts[sizes[j]]
+			}
+			return sizes[i].X*sizes[i].Y > sizes[j].X*sizes[j].Y
+		})
+		if len(sizes) == 0 {
+			log.Fatalf("Could not read the size of any of the %d images", len(srcfilenames))
+		}
+		for _, size := range sizes {
+			log.Printf("Image size %dx%d : %d images", size.X, size.Y, counts[size])
+		}
+		standardsize = sizes[0]
+		log.Printf("Standardizing all images to %dx%d", standardsize.X, standardsize.Y)
+	}
+
+	if detector != nil {
+		if croppath, err = FollowPath(detector, srcfilenames, openSource, *cropwidth, *cropheight, *verbose); err != nil {
+			log.Fatalf("Error following the subject for -cropfollow : %s", err)
+		}
+	}
+
+	//With -cropgrid every source image is listed once for each of its tiles & tiles holds
+	//which tile each entry is. Everything after this treats each tile as an image of its own
+	var tiles []int
+	if gridrows > 0 {
+		expanded := make([]string, 0, len(srcfilenames)*gridrows*gridcols)
+		var expandedframes []int
+		for j, filename := range srcfilenames {
+			for tile := 0; tile < gridrows*gridcols; tile++ {
+				expanded = append(expanded, filename)
+				tiles = append(tiles, tile)
+				if anim != nil {
+					expandedframes = append(expandedframes, animframe[j])
+				}
+			}
+		}
+		animframe = expandedframes
+		if *verbose {
+			log.Printf("Splitting %d images into %d tiles", len(srcfilenames), len(expanded))
+		}
+		srcfilenames = expanded
+	}
+
+	//With -mkdir missing destination directories are created up front so that the live
+	//preview can be written into them too
+	if *mkdir {
+		for _, dest := range destnames {
+			dir := filepath.Dir(dest)
+			if _, err := os.Stat(dir); err == nil {
+				continue
+			}
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				log.Fatalf("Error creating the destination directory %s : %s", dir, err)
+			}
+			if *verbose {
+				log.Printf("Created destination directory %s", dir)
+			}
+		}
+	}
+
+	//Stop collecting frames on Ctrl-C but still write out whatever we have so far
+	//A second Ctrl-C is left to kill the process as usual
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	stop := make(chan struct{})
+	go func() {
+		<-interrupt
+		signal.Stop(interrupt)
+		close(stop)
+	}()
+
+	//With -timing the time spent in each stage is added up & reported at the end while
+	//-timingcsv also keeps the time spent on each image
+	var timer *StageTimer
+	if *timing || *timingcsv != "" {
+		timer = NewStageTimer()
+	}
+
+	var solidskipped int32
+	var partialalpha int32
+
+	//readImage decodes & trims source image ctr ready for the rest of its processing
+	readImage := func(ctr int) (image.Image, error) {
+		start := time.Now()
+		img, err := openSource(ctr)
+		if err != nil {
+			return nil, err
+		}
+		img = NormalizeImage(img)
+		//Only the source is checked since resizing & rotating soften hard edges too
+		if HasPartialAlpha(img) {
+			atomic.AddInt32(&partialalpha, 1)
+		}
+		if *standardize {
+			img = StandardizeImage(standardsize, img, *verbose)
+		}
+		timer.AddImage("decode", ctr, start)
+
+		start = time.Now()
+		if *trimuniform {
+			img = TrimImage(uniformtrim, img, *verbose)
+		} else if *trim {
+			img = TrimImage(FindTrim(*trimtolerance, img), img, *verbose)
+		}
+		timer.AddImage("trim", ctr, start)
+		return img, nil
+	}
+
+	//With -cropgrid each sheet is read once by the first of its tiles to be processed & the
+	//rest of its tiles are cut from the same image, which is dropped once all have been cut
+	type gridSheet struct {
+		once sync.Once
+		img  image.Image
+		err  error
+		left int32
+	}
+	var sheets []*gridSheet
+	if tiles != nil {
+		sheets = make([]*gridSheet, len(srcfilenames)/(gridrows*gridcols))
+		for j := range sheets {
+			sheets[j] = &gridSheet{left: int32(gridrows * gridcols)}
+		}
+	}
+
+	//The transform hints of each file are read once & shared by all the tiles cut from it
+	type fileHints struct {
+		once  sync.Once
+		hints TransformHints
+	}
+	var hintsmu sync.Mutex
+	filehints := make(map[string]*fileHints)
+
+	//processImage reads & transforms a single source image. It returns nil if the image
+	//has to be skipped
+	processImage := func(ctr int) image.Image {
+		filename := srcfilenames[ctr]
+		var img image.Image
+		var err error
+		if tiles != nil {
+			sheet := sheets[ctr/(gridrows*gridcols)]
+			sheet.once.Do(func() {
+				sheet.img, sheet.err = readImage(ctr)
+				if sheet.err != nil {
+					return
+				}
+				if b := sheet.img.Bounds(); b.Dx() < gridcols || b.Dy() < gridrows {
+					sheet.img, sheet.err = nil, fmt.Errorf("its %dx%d pixels are too few to split into a %dx%d grid", b.Dx(), b.Dy(), gridrows, gridcols)
+				}
+			})
+			img, err = sheet.img, sheet.err
+			if atomic.AddInt32(&sheet.left, -1) == 0 {
+				sheet.img = nil
+			}
+		} else {
+			img, err = readImage(ctr)
+		}
+		if err != nil {
+			log.Printf("Skipping file %s due to error reading it :%s", filename, err)
+			return nil
+		}
+
+		if *verbose {
+			log.Printf("Parsing image %d of %d : %s", ctr, len(srcfilenames), filename)
+		}
+
+		if tiles != nil {
+			img = TileImage(tiles[ctr], gridrows, gridcols, img, *verbose)
+		}
+		start := time.Now()
+
+		//Hints embedded by a capture tool replace the crop & rotate flags for this image.
+		//Standard input can't be read again & the frames of an animated GIF have none
+		var hints TransformHints
+		if *exiftransforms && filename != "-" && anim == nil {
+			hintsmu.Lock()
+			file := filehints[filename]
+			if file == nil {
+				file = &fileHints{}
+				filehints[filename] = file
+			}
+			hintsmu.Unlock()
+			file.once.Do(func() {
+				var ok bool
+				var err error
+				if file.hints, ok, err = ReadTransformHints(filename); err != nil {
+					log.Printf("Ignoring transform hints in %s due to error reading them :%s", filename, err)
+				} else if ok && *verbose {
+					log.Printf("Applying transform hints from %s", filename)
+				}
+			})
+			hints = file.hints
+		}
+
+		for j, op := range operations {
+			start = time.Now()
+			switch op {
+			case "crop":
+				left, top, width, height := *cropleft, *croptop, *cropwidth, *cropheight
+				if croppath != nil {
+					//images beyond the end of the path stay at its last offset
+					offset := croppath[len(croppath)-1]
+					if ctr < len(croppath) {
+						offset = croppath[ctr]
+					}
+					left, top = offset.X, offset.Y
+				}
+				if hints.HasCrop {
+					left, top, width, height = hints.Left, hints.Top, hints.Width, hints.Height
+				}
+				//The crop is checked against the image as it is when the crop runs which
+				//differs from the source image when -order puts eg. rotate before crop
+				if err := CheckCrop(left, top, width, height, img.Bounds()); err != nil {
+					if j > 0 {
+						log.Printf("Skipping file %s since the %s, which is its size after %s", filename, err, strings.Join(operations[:j], ", "))
+					} else {
+						log.Printf("Skipping file %s since the %s", filename, err)
+					}
+					return nil
+				}
+				img = CropImage(left, top, width, height, img, *verbose)
+				if *cropaspect != "" {
+					img = AspectCropImage(aspectwidth, aspectheight, img, *verbose)
+				}
+			case "scale":
+				if zoom {
+					//Interpolate the scale linearly from the first to the last image
+					t := 0.0
+					if len(srcfilenames) > 1 {
+						t = float64(ctr) / float64(len(srcfilenames)-1)
+					}
+					img = ZoomImage(*scalestart+(*scaleend-*scalestart)*t, math.Min(*scalestart, *scaleend), *linearresize, img, *verbose)
+				} else {
+					img = ScaleImage(scale, *linearresize, img, *verbose)
+				}
+			case "rotate":
+				if hints.HasRotate {
+					img = RotateImage(hints.Rotate, img, *verbose)
+				} else {
+					img = RotateImage(rotate, img, *verbose)
+				}
+			case "flip":
+				img = FlipImage(*flip, img, *verbose)
+			}
+			timer.AddImage(op, ctr, start)
+		}
+
+		//Solid frames such as black frames at scene cuts flash when the animation loops
+		if *skipsolid && IsSolid(*skipsolidtolerance, img) {
+			atomic.AddInt32(&solidskipped, 1)
+			if *verbose {
+				log.Printf("Skipping file %s since it is a single solid colour", filename)
+			}
+			return nil
+		}
+
+		start = time.Now()
+		if *denoise {
+			img = DenoiseImage(*denoisestrength, img, *verbose)
+		}
+		if lut != nil {
+			img = ApplyLUT(lut, img)
+		}
+		if *rotateauto {
+			tilt := FindTilt(img)
+			if *verbose {
+				log.Printf("Leveling horizon of %s tilted by %.1f degrees", filename, tilt)
+			}
+			//The canvas fits the largest tilt corrected so every frame stays the same size
+			var canvas image.Point
+			if *rotateexpand {
+				canvas = RotatedSize(img.Bounds().Size(), maxTilt)
+			}
+			img = RotateAngleImage(-tilt, canvas, background, img, *verbose)
+		}
+		if *spin != 0 {
+			//Angles are spread so the last frame stops one step short of the total which
+			//lets a full 360 degree spin loop smoothly
+			angles := make([]float64, len(srcfilenames))
+			for j := range angles {
+				angles[j] = *spin * float64(j) / float64(len(srcfilenames))
+			}
+			//The canvas fits every angle of the spin so every frame stays the same size
+			var canvas image.Point
+			if *rotateexpand {
+				canvas = RotatedSize(img.Bounds().Size(), angles...)
+			}
+			angle := angles[ctr]
+			if *spindirection == "ccw" {
+				angle = -angle
+			}
+			img = RotateAngleImage(angle, canvas, background, img, *verbose)
+		}
+		if *mirror {
+			img = MirrorImage(*mirroraxis, img, *verbose)
+		}
+		if *presetname != "" {
+			img = FitImage(preset.Width, preset.Height, preset.Pad, anchor, img, *verbose)
+		}
+		img = PixelateImage(*pixelate, img, *verbose)
+		if *halftone {
+			img = HalftoneImage(*halftonedotsize, img, *verbose)
+		}
+		if *scanlines {
+			img = ScanlineImage(*scanlineintensity, img, *verbose)
+		}
+		if *sidecartext {
+			if text, err := SidecarText(filename); err != nil {
+				log.Printf("Not annotating %s due to error reading its sidecar text :%s", filename, err)
+			} else {
+				img = AnnotateImage(text, img, *verbose)
+			}
+		}
+		img = FlattenImage(background, img, *verbose)
+		timer.AddImage("effects", ctr, start)
+
+		return img
+	}
+
+	//With -livepreview the destination is rewritten every few frames with the frames which
+	//have been done so far so that progress can be checked on long runs
+	loopcount := 0
+	if *noloop {
+		loopcount = -1
+	} else if anim != nil {
+		loopcount = anim.LoopCount
+	}
+	//Frames are done in any order by the workers so the preview holds the frames up to the
+	//first one not done yet. Skipped images are done with a nil frame which is left out of the
+	//preview. The frames are picked under previewmu but encoded & written outside it so the
+	//workers aren't held up, with previewwritten making sure a preview never replaces a
+	//longer one written by another worker
+	var previewmu, previewwritemu sync.Mutex
+	previewframes, previewdone := make([]*image.Paletted, len(srcfilenames)), make([]bool, len(srcfilenames))
+	previewcount, nextpreview, previewwritten := 0, *livepreview, 0
+	frameDone := func(j int, frame *image.Paletted) {
+		previewmu.Lock()
+		previewframes[j], previewdone[j] = frame, true
+		for previewcount < len(previewdone) && previewdone[previewcount] {
+			previewcount++
+		}
+		if previewcount < nextpreview || previewcount == len(previewdone) {
+			previewmu.Unlock()
+			return
+		}
+		nextpreview = previewcount + *livepreview
+		var frames []*image.Paletted
+		var animdelays []int
+		for j, frame := range previewframes[:previewcount] {
+			if frame != nil {
+				frames = append(frames, frame)
+				if anim != nil {
+					animdelays = append(animdelays, anim.Delays[animframe[j]])
+				}
+			}
+		}
+		previewmu.Unlock()
+
+		//The preview is timed like the final GIF before the effects across frames
+		delays := repeatDelays(len(frames), delay)
+		if anim != nil && !delayset && clip.FPS == 0 {
+			delays = animdelays
+		}
+		delays = ScaleDelays(*speed, delays)
+		if *minbrowserdelay {
+			ClampDelays(minBrowserDelay, delays)
+		}
+		buf := bytes.Buffer{}
+		if err := EncodeGIF(&buf, frames, delays, loopcount, globaltable); err != nil {
+			log.Printf("Error encoding live preview :%s", err)
+			return
+		}
+		previewwritemu.Lock()
+		defer previewwritemu.Unlock()
+		if len(frames) <= previewwritten {
+			return
+		}
+		previewwritten = len(frames)
+		if *verbose {
+			log.Printf("Writing live preview of %d frames to %s", len(frames), destnames[0])
+		}
+		if err := WriteFileAtomic(destnames[0], buf.Bytes()); err != nil {
+			log.Printf("Error writing live preview %s : %s", destnames[0], err)
+		}
+	}
+
+	//framePalette returns the palette for img & whether it is exactly the image's colours
+	framePalette := func(img image.Image) (color.Palette, bool) {
+		framepal := pal
+		if quantcolors > 0 {
+			framepal = MedianCutPalette(img, quantcolors)
+		}
+		//-adaptivecolors gives simple frames a palette of exactly their colours & picks 256
+		//colours for the rest unless -quality has picked a count
+		if *adaptivecolors && !fixedpalette {
+			if exact, ok := ExactPalette(img, 256); ok {
+				return exact, true
+			} else if quantcolors == 0 {
+				framepal = MedianCutPalette(img, 256)
+			}
+		}
+		return framepal, false
+	}
+
+	//quantizeFrame converts frame j made from source image number index to a paletted frame
+	//with framepal, or a palette picked for it by framePalette if framepal is nil
+	quantizeFrame := func(j int, img image.Image, index int, framepal color.Palette, exact bool) *image.Paletted {
+		drawer := ditherer
+		if *smartdither && CountColors(img, *smartditherthreshold+1) <= *smartditherthreshold {
+			if *verbose {
+				log.Printf("Not dithering frame %d since it has at most %d colours", j, *smartditherthreshold)
+			}
+			drawer = draw.Src
+		}
+		start := time.Now()
+		if framepal == nil {
+			framepal, exact = framePalette(img)
+		}
+		if exact {
+			if *verbose {
+				log.Printf("Using the %d colours of frame %d as its palette", len(framepal), j)
+			}
+			drawer = draw.Src
+		}
+		frame := QuantizeImage(framepal, drawer, img)
+		timer.AddImage("quantize", index, start)
+		return frame
+	}
+
+	//Effects which look at or blend frames together need all the processed frames before
+	//quantizing. Otherwise each image is quantized as soon as it is processed so only the
+	//far smaller paletted frames are kept in memory
+	stabilize := *stabilizepalette && (quantcolors > 0 || *adaptivecolors && !fixedpalette)
+	sequence := *trimframes || *autowb != "none" || *autolevels != "none" || *accumulate || *motionblur || *overlap || *fuse != "" || *interpolate || stabilize
+
+	//The live preview follows the images as they are processed, which is the slow part. When
+	//the frames are quantized later the preview quantizes a copy of each image with the fixed
+	//palette, before any effects across frames
+	var images []image.Image
+	var quantizedimages []*image.Paletted
+	if sequence || *maxbytes > 0 {
+		images = make([]image.Image, len(srcfilenames))
+	}
+	if !sequence {
+		quantizedimages = make([]*image.Paletted, len(srcfilenames))
+	}
+	dispatched := forEach(*threads, len(srcfilenames), stop, func(ctr int) {
+		img := processImage(ctr)
+		var frame *image.Paletted
+		if sequence {
+			images[ctr] = img
+			if img != nil && *livepreview > 0 {
+				frame = QuantizeImage(pal, ditherer, img)
+			}
+		} else if img != nil {
+			frame = quantizeFrame(ctr, img, ctr, nil, false)
+			quantizedimages[ctr] = frame
+			if *maxbytes > 0 {
+				images[ctr] = img
+			}
+		}
+		if *livepreview > 0 {
+			frameDone(ctr, frame)
+		}
+	})
+	if dispatched < len(srcfilenames) {
+		log.Printf("Interrupted after %d of %d images.. writing partial animated GIF", dispatched, len(srcfilenames))
+	}
+	if *skipsolid && *verbose {
+		log.Printf("Skipped %d solid colour images", solidskipped)
+	}
+	//GIF pixels are either fully transparent or opaque so soft edges are flattened onto the
+	//background, which is easy to miss when converting cut out PNG sequences
+	if partialalpha > 0 {
+		log.Printf("%d images are partly transparent which GIF can't hold so they are flattened onto -background, APNG or WebP keep soft edges", partialalpha)
+	}
+
+	//-maxbytes remakes frames from the images they were quantized from so budgetimages keeps
+	//the image of each frame, which stays with the frame through any reordering
+	var budgetimages map[*image.Paletted]image.Image
+	if *maxbytes > 0 {
+		budgetimages = make(map[*image.Paletted]image.Image)
+	}
+
+	//sources keeps the file name each frame was made from & entries its index in srcfilenames
+	var imgs []image.Image
+	var frames []*image.Paletted
+	var sources []string
+	var entries []int
+	var animdelays []int
+	for ctr := range srcfilenames {
+		if sequence && images[ctr] != nil {
+			imgs = append(imgs, images[ctr])
+		} else if !sequence && quantizedimages[ctr] != nil {
+			frames = append(frames, quantizedimages[ctr])
+			if budgetimages != nil {
+				budgetimages[quantizedimages[ctr]] = images[ctr]
+			}
+		} else {
+			continue
+		}
+		sources = append(sources, srcfilenames[ctr])
+		entries = append(entries, ctr)
+		if anim != nil {
+			animdelays = append(animdelays, anim.Delays[animframe[ctr]])
+		}
+	}
+	images, quantizedimages = nil, nil
+
+	//origin tracks the processed image each frame was made from so that -interpolate keeps
+	//the total duration the same
+	imagesources := sources
+	origin := identityOrder(len(sources))
+	if sequence {
+		//Captures often sit still before & after the action so -trimframes keeps just the
+		//frames from where the motion starts to where it stops
+		if *trimframes {
+			first, last := StillEnds(*trimframestolerance, imgs)
+			log.Printf("Trimmed %d still frames from the start & %d from the end", first, len(imgs)-last)
+			imgs, sources, entries = imgs[first:last], sources[first:last], entries[first:last]
+			if anim != nil {
+				animdelays = animdelays[first:last]
+			}
+		}
+
+		start := time.Now()
+		imgs = AutoWhiteBalance(*autowb, imgs, *verbose)
+		imgs = AutoLevels(*autolevels, imgs, *verbose)
+		if *accumulate {
+			imgs = Accumulate(*accumulatemode, imgs, *verbose)
+		}
+		if *motionblur {
+			imgs = MotionBlur(*motionblurstrength, imgs, *verbose)
+		}
+		if *overlap {
+			imgs = Overlap(*overlapamount, imgs, *verbose)
+		}
+
+		//With -fuse the frames are bracketed exposures merged into a single still & no GIF is
+		//written
+		if *fuse != "" {
+			fused, err := FuseExposures(imgs, *verbose)
+			if err != nil {
+				log.Fatalf("Error fusing exposures :%s", err)
+			}
+			if err := imaging.Save(fused, *fuse); err != nil {
+				log.Fatalf("Error writing fused image %s : %s", *fuse, err)
+			}
+			timer.Add("sequence", start)
+			if *timing {
+				timer.Report()
+			}
+			return
+		}
+		imagesources = sources
+		origin = identityOrder(len(imgs))
+		if *interpolate {
+			imgs, origin = Interpolate(*interpolatefactor, imgs, *verbose)
+			sources, entries = pickStrings(origin, sources), pickInts(origin, entries)
+		}
+		timer.Add("sequence", start)
+
+		//-stabilizepalette picks the palettes of all the frames first since each one is
+		//matched to the palette of the frame before it. Fixed palettes are already the same
+		//for every frame
+		var framepals []color.Palette
+		var exactpals []bool
+		if stabilize {
+			start := time.Now()
+			framepals, exactpals = make([]color.Palette, len(imgs)), make([]bool, len(imgs))
+			forEach(*threads, len(imgs), nil, func(j int) {
+				framepals[j], exactpals[j] = framePalette(imgs[j])
+			})
+			replaced := StabilizePalettes(*stabilizetolerance, framepals, exactpals)
+			if *verbose {
+				log.Printf("Matched %d colours to the palette of the frame before to stabilize the palettes", replaced)
+			}
+			timer.Add("quantize", start)
+		}
+
+		//If we were interrupted while processing images, quantize everything processed so
+		//far. Otherwise an interrupt now gives an animation of the frames quantized up to
+		//that point
+		quantizestop := stop
+		if dispatched < len(srcfilenames) {
+			quantizestop = nil
+		}
+		frames = make([]*image.Paletted, len(imgs))
+		quantized := forEach(*threads, len(imgs), quantizestop, func(j int) {
+			if framepals != nil {
+				frames[j] = quantizeFrame(j, imgs[j], entries[j], framepals[j], exactpals[j])
+			} else {
+				frames[j] = quantizeFrame(j, imgs[j], entries[j], nil, false)
+			}
+		})
+		if quantized < len(imgs) {
+			log.Printf("Interrupted after quantizing %d of %d frames.. writing partial animated GIF", quantized, len(imgs))
+			frames, sources, origin = frames[:quantized], sources[:quantized], origin[:quantized]
+		}
+		if budgetimages != nil {
+			for j, frame := range frames {
+				budgetimages[frame] = imgs[j]
+			}
+		}
+		imgs = nil
+	}
+
+	if *verbose {
+		log.Printf("Parsed all images.. now attemting to create animated GIF %s", strings.Join(destnames, ", "))
+	}
+
+	//Features changing the frame order work on frame indexes so that the frames and their
+	//source file names stay together. The delays are worked out for the frames before
+	//-targetframes & follow them once they are resampled
+	resampled := ResampleOrder(*targetframes, len(frames), *verbose)
+	rotation := RotateOrder(*rotateframes, len(resampled), *verbose)
+	order := pickInts(rotation, resampled)
+	frames, sources = pickFrames(order, frames), pickStrings(order, sources)
+
+	//GIF compresses frames that change little far better so this helps explain large files
+	if *verbose && len(frames) > 1 {
+		total := 0.0
+		for j := 1; j < len(frames); j++ {
+			total += ChangedFraction(frames[j-1], frames[j])
+		}
+		log.Printf("On average %.1f%% of pixels change between consecutive frames", total*100/float64(len(frames)-1))
+	}
+
+	if *poster != "" && len(frames) > 0 {
+		if *verbose {
+			log.Printf("Writing first frame as poster image %s", *poster)
+		}
+		if err := imaging.Save(frames[0], *poster); err != nil {
+			log.Printf("Error writing poster image %s : %s", *poster, err)
+		}
+	}
+
+	if *thumb != "" && len(frames) > 0 {
+		if *thumbindex < 0 || *thumbindex >= len(frames) {
+			log.Printf("Skipping thumbnail since thumbindex %d is outside the %d frames", *thumbindex, len(frames))
+		} else {
+			if *verbose {
+				log.Printf("Writing frame %d as %d pixel thumbnail %s", *thumbindex, *thumbsize, *thumb)
+			}
+			thumbimg := imaging.Fit(frames[*thumbindex], *thumbsize, *thumbsize, imaging.Lanczos)
+			if err := imaging.Save(thumbimg, *thumb); err != nil {
+				log.Printf("Error writing thumbnail %s : %s", *thumb, err)
+			}
+		}
+	}
+
+	//Frames are all quantized to the same palette, either from -palettefile, -palette or the
+	//default Plan 9 palette, so the first frame's palette is the palette of the GIF. The -quality
+	//levels picking colours for each frame write the palette of the first frame
+	if *dumppalette != "" && len(frames) > 0 {
+		if *verbose {
+			log.Printf("Writing the %d colour palette to %s", len(frames[0].Palette), *dumppalette)
+		}
+		if err := SavePalette(*dumppalette, frames[0].Palette); err != nil {
+			log.Printf("Error writing palette %s : %s", *dumppalette, err)
+		}
+	}
+
+	//Delays are worked out for the processed images & then shared out over the frames made
+	//from each image by -interpolate & over those -targetframes picks so the total duration
+	//is kept
+	delays := repeatDelays(len(origin), delay)
+	if *interpolate {
+		delays = SpreadDelays(repeatDelays(len(imagesources), delay), origin)
+	}
+	//An animated GIF keeps its own timing unless -delay or a -clip frame rate replaces it
+	if anim != nil && !delayset && clip.FPS == 0 {
+		delays = SpreadDelays(animdelays, origin)
+	}
+	if *parsetiming {
+		if imagedelays, err := TimestampDelays(imagesources); err != nil {
+			log.Printf("Using -delay since the capture times could not be read from the file names : %s", err)
+		} else {
+			if *verbose {
+				log.Printf("Using delays from the capture times in the file names")
+			}
+			delays = SpreadDelays(imagedelays, origin)
+		}
+	}
+	if *autodelay && (sourcetimes != nil || *timestampfile == "") {
+		var imagedelays []int
+		var err error
+		if sourcetimes != nil {
+			times := make([]float64, len(imagesources))
+			for j, filename := range imagesources {
+				times[j] = sourcetimes[filename]
+			}
+			imagedelays, err = GapDelays(times, imagesources)
+		} else {
+			imagedelays, err = TimestampDelays(imagesources)
+		}
+		if err != nil {
+			log.Printf("Using -delay since the frame times could not be worked out : %s", err)
+		} else {
+			if *verbose {
+				log.Printf("Using delays from the frame times to play at the original speed")
+			}
+			delays = SpreadDelays(imagedelays, origin)
+		}
+	}
+	delays = pickInts(rotation, ResampleDelays(len(resampled), delays))
+	//Repeated frames keep their delays so -repeat lengthens the animation
+	if repeats != nil {
+		order, err := RepeatOrder(repeats, len(frames), *verbose)
+		if err != nil {
+			log.Fatalf("Error repeating frames for -repeat : %s", err)
+		}
+		frames, sources, delays = pickFrames(order, frames), pickStrings(order, sources), pickInts(order, delays)
+	}
+	delays = ScaleDelays(*speed, delays)
+	if *jitter > 0 {
+		if *seed == 0 {
+			*seed = time.Now().UnixNano()
+		}
+		if *verbose {
+			log.Printf("Varying delays by up to %g%% with seed %d", *jitter, *seed)
+		}
+		delays = JitterDelays(*jitter, *seed, delays)
+	}
+	//-maxduration truncates after the delays are final so it counts the time the frames
+	//actually play for
+	if *maxduration > 0 {
+		if keep := CapDuration(*maxduration, delays); keep < len(frames) {
+			log.Printf("Dropped the last %d of %d frames to keep within %gs", len(frames)-keep, len(frames), *maxduration)
+			frames, sources, delays = frames[:keep], sources[:keep], delays[:keep]
+		}
+	}
+	//finishDelays adds the pause before looping, after -speed so it stays as given, raises
+	//delays for browsers with -minbrowserdelay & holds the last frame with -noloop. It
+	//returns how many delays were raised for browsers
+	finishDelays := func(delays []int) int {
+		if *loopdelay > 0 && len(delays) > 0 {
+			delays[len(delays)-1] += *loopdelay
+		}
+		clamped := 0
+		if *minbrowserdelay {
+			clamped = ClampDelays(minBrowserDelay, delays)
+		}
+		//Some viewers loop every GIF so the last frame is also held for a long time
+		if *noloop && len(delays) > 0 && delays[len(delays)-1] < noloopHold {
+			delays[len(delays)-1] = noloopHold
+		}
+		return clamped
+	}
+	//-reverseoutput plays the frames backwards with the same pause & hold at the end
+	var reversedelays []int
+	if *reverseoutput {
+		reversedelays = pickInts(reverseOrder(len(delays)), delays)
+		finishDelays(reversedelays)
+	}
+	clamped := finishDelays(delays)
+	if *minbrowserdelay {
+		if clamped > 0 && *verbose {
+			log.Printf("Raised the delay of %d frames to %d for browsers", clamped, minBrowserDelay)
+		}
+	} else {
+		for _, d := range delays {
+			if d < minBrowserDelay {
+				log.Printf("The GIF may play slower than intended in browsers since they play delays below %d as 10, -minbrowserdelay avoids this", minBrowserDelay)
+				break
+			}
+		}
+	}
+
+	if *timingfile != "" {
+		if *verbose {
+			log.Printf("Writing frame timings to %s", *timingfile)
+		}
+		if err := WriteTiming(*timingfile, delays, sources); err != nil {
+			log.Printf("Error writing frame timings %s : %s", *timingfile, err)
+		}
+	}
+
+	if *spritesheet != "" && len(frames) > 0 {
+		if *verbose {
+			log.Printf("Writing %d frames as sprite sheet %s", len(frames), *spritesheet)
+		}
+		if err := WriteSpriteSheet(*spritesheet, frames, delays, *spritesheetcols, loopcount); err != nil {
+			log.Printf("Error writing sprite sheet %s : %s", *spritesheet, err)
+		}
+	}
+
+	//The GIF is encoded in memory so that it can be checked against a byte budget & patched
+	//with the parts image/gif doesn't write before the destination is written once
+	var credit string
+	if *creditcomment {
+		credit = fmt.Sprintf("Created by goanigiffy %s https://github.com/srinathh/goanigiffy", version)
+	}
+	encode := func(frames []*image.Paletted, delays []int) []byte {
+		var encoded []byte
+		var err error
+		if *maxbytes > 0 {
+			imgs := make([]image.Image, len(frames))
+			for j, frame := range frames {
+				imgs[j] = budgetimages[frame]
+			}
+			encoded, err = EncodeWithinBudget(*maxbytes, ditherer, frames, imgs, fixedpalette, delays, loopcount, globaltable, *verbose)
+		} else {
+			buf := bytes.Buffer{}
+			err = EncodeGIF(&buf, frames, delays, loopcount, globaltable)
+			encoded = buf.Bytes()
+		}
+		if err != nil {
+			log.Fatalf("Error encoding output into animated gif :%s", err)
+		}
+
+		if *interlace {
+			if encoded, err = InterlaceGIF(encoded); err != nil {
+				log.Fatalf("Error interlacing animated gif :%s", err)
+			}
+		}
+
+		if encoded, err = AddComments(encoded, *comment, credit); err != nil {
+			log.Fatalf("Error adding comments to animated gif :%s", err)
+		}
+		return encoded
+	}
+	start := time.Now()
+	encoded := encode(frames, delays)
+	timer.Add("encode", start)
+
+	//The same encoded GIF is written to every destination. Each is written atomically so an
+	//existing GIF is only replaced once the new one is complete
+	for _, dest := range destnames {
+		if err := WriteFileAtomic(dest, encoded); err != nil {
+			log.Fatalf("Error writing output animated gif %s : %s", dest, err)
+		}
+	}
+
+	if *reverseoutput {
+		reversed := encode(pickFrames(reverseOrder(len(frames)), frames), reversedelays)
+		for _, dest := range destnames {
+			reversename := ReverseName(dest)
+			if *verbose {
+				log.Printf("Writing the reversed animated gif %s", reversename)
+			}
+			if err := WriteFileAtomic(reversename, reversed); err != nil {
+				log.Fatalf("Error writing reversed animated gif %s : %s", reversename, err)
+			}
+		}
+	}
+	if *timing {
+		timer.Report()
+	}
+	if *timingcsv != "" {
+		if err := timer.WriteCSV(*timingcsv, srcfilenames, animframe, tiles); err != nil {
+			log.Printf("Error writing timings %s : %s", *timingcsv, err)
+		}
+	}
+
+	if manifest != nil {
+		if err := ioutil.WriteFile(*hashmanifest, manifest, 0644); err != nil {
+			log.Printf("Error writing manifest %s : %s", *hashmanifest, err)
+		}
+	}
+
+	if *htmlpreview != "" {
+		if *verbose {
+			log.Printf("Writing HTML preview to %s", *htmlpreview)
+		}
+		if err := WritePreview(*htmlpreview, destnames[0], encoded, frames, delays); err != nil {
+			log.Printf("Error writing HTML preview %s : %s", *htmlpreview, err)
+		}
+	}
+
+	if *verify {
+		for _, dest := range destnames {
+			if err := VerifyGIF(dest, len(frames)); err != nil {
+				log.Fatalf("Error verifying the written animated gif %s : %s", dest, err)
+			}
+			if *verbose {
+				log.Printf("Verified %s decodes with all %d frames", dest, len(frames))
+			}
+		}
 	}
-	opfile.Close()
 }
@@ -0,0 +1,493 @@
+/*
+   Copyright 2014 Hariharan Srinath
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//testdata/cmyk.jpg is a 16x8 Adobe CMYK JPEG, cyan on the left & red on the right
+func TestCMYKJPEG(t *testing.T) {
+	img, err := OpenImage("testdata/cmyk.jpg", 0, false)
+	if err != nil {
+		t.Fatalf("decoding cmyk.jpg: %s", err)
+	}
+	if _, ok := img.(*image.CMYK); !ok {
+		t.Fatalf("cmyk.jpg decoded as %T, want *image.CMYK", img)
+	}
+
+	want := map[image.Point]color.NRGBA{
+		image.Pt(4, 4):  {0, 255, 255, 255},
+		image.Pt(12, 4): {255, 0, 0, 255},
+	}
+	nrgba := NormalizeImage(img)
+	for p, c := range want {
+		if got := nrgba.NRGBAAt(p.X, p.Y); !closeColor(got, c) {
+			t.Errorf("normalized pixel %v is %v, want %v", p, got, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	frame := QuantizeImage(palette.Plan9, draw.Src, nrgba)
+	if err := EncodeGIF(&buf, []*image.Paletted{frame}, []int{10}, 0, true); err != nil {
+		t.Fatalf("encoding gif: %s", err)
+	}
+	g, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatalf("decoding gif: %s", err)
+	}
+	for p, c := range want {
+		got := color.NRGBAModel.Convert(g.Image[0].At(p.X, p.Y)).(color.NRGBA)
+		if !closeColor(got, c) {
+			t.Errorf("gif pixel %v is %v, want %v", p, got, c)
+		}
+	}
+}
+
+//closeColor reports whether every channel of a & b is within 2 of each other
+func closeColor(a, b color.NRGBA) bool {
+	for _, d := range []int{int(a.R) - int(b.R), int(a.G) - int(b.G), int(a.B) - int(b.B), int(a.A) - int(b.A)} {
+		if d > 2 || d < -2 {
+			return false
+		}
+	}
+	return true
+}
+
+//Offsets & sizes near the top of the uint32 range must be rejected rather than wrapping
+//around, which panicked on 32 bit platforms
+func TestExifCommentsCrafted(t *testing.T) {
+	header := []byte{'I', 'I', 42, 0, 0xf0, 0xff, 0xff, 0xff}
+	if _, err := exifComments(header); err == nil {
+		t.Errorf("exifComments accepted a directory offset past the end of the data")
+	}
+
+	//One entry with a huge size & offset followed by no next directory
+	tiff := []byte{'I', 'I', 42, 0, 8, 0, 0, 0, 1, 0,
+		0x0e, 0x01, 2, 0, 0xff, 0xff, 0xff, 0xff, 0xf0, 0xff, 0xff, 0xff,
+		0, 0, 0, 0}
+	comments, err := exifComments(tiff)
+	if err != nil || len(comments) != 0 {
+		t.Errorf("exifComments gave %q, %v for an entry pointing past the end of the data", comments, err)
+	}
+}
+
+//horizon draws a light sky over dark ground split by a line through the centre tilted by
+//degrees clockwise, with the pixels the line crosses shaded by how much of them is sky
+func horizon(width, height int, degrees float64) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	slope := math.Tan(degrees * math.Pi / 180)
+	const samples = 8
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			//The sky in each of a few thin columns across the pixel is exact vertically
+			sky := 0.0
+			for s := 0; s < samples; s++ {
+				px := float64(x) + (float64(s)+0.5)/samples - float64(width)/2
+				top := float64(y) - float64(height)/2
+				sky += math.Max(0, math.Min(1, slope*px-top)) / samples
+			}
+			v := uint8(40 + 160*sky + 0.5)
+			img.SetNRGBA(x, y, color.NRGBA{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+func TestFindTilt(t *testing.T) {
+	for _, degrees := range []float64{0, 3, -4.5, 7, -11, 14} {
+		img := horizon(1000, 700, degrees)
+		if got := FindTilt(img); math.Abs(got-degrees) > 0.5 {
+			t.Errorf("FindTilt of a horizon tilted %g degrees gave %g", degrees, got)
+		}
+	}
+}
+
+func TestParseRepeats(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []Repeat
+		ok   bool
+	}{
+		{"8x3", []Repeat{{8, 8, 3}}, true},
+		{"3-5x2, 8X3", []Repeat{{3, 5, 2}, {8, 8, 3}}, true},
+		{"0x1", []Repeat{{0, 0, 1}}, true},
+		{"3-5x2,5x3", nil, false},
+		{"3-5x2,1-3x2", nil, false},
+		{"4x2,4x3", nil, false},
+		{"5-3x2", nil, false},
+		{"-1x2", nil, false},
+		{"3x0", nil, false},
+		{"3", nil, false},
+		{"ax2", nil, false},
+	}
+	for _, test := range tests {
+		got, err := ParseRepeats(test.spec)
+		if (err == nil) != test.ok {
+			t.Errorf("ParseRepeats(%q) gave error %v", test.spec, err)
+			continue
+		}
+		if test.ok && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseRepeats(%q) gave %v, want %v", test.spec, got, test.want)
+		}
+	}
+
+	//Ranges are only checked against the number of frames once the frames are known
+	repeats, err := ParseRepeats("8-10x2")
+	if err != nil {
+		t.Fatalf("ParseRepeats(8-10x2) gave error %s", err)
+	}
+	if _, err := RepeatOrder(repeats, 10, false); err == nil {
+		t.Errorf("RepeatOrder accepted a range ending beyond the last of 10 frames")
+	}
+	if order, err := RepeatOrder(repeats, 11, false); err != nil || len(order) != 14 {
+		t.Errorf("RepeatOrder of 8-10x2 over 11 frames gave %v, %v", order, err)
+	}
+}
+
+func TestParseDelays(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []int
+		ok   bool
+	}{
+		{"3", []int{3}, true},
+		{"10, 3,3 ,10", []int{10, 3, 3, 10}, true},
+		{"0", []int{0}, true},
+		{"-1", nil, false},
+		{"3,,3", nil, false},
+		{"1.5", nil, false},
+		{"", nil, false},
+	}
+	for _, test := range tests {
+		got, err := ParseDelays(test.spec)
+		if (err == nil) != test.ok {
+			t.Errorf("ParseDelays(%q) gave error %v", test.spec, err)
+			continue
+		}
+		if test.ok && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseDelays(%q) gave %v, want %v", test.spec, got, test.want)
+		}
+	}
+}
+
+func TestParseLength(t *testing.T) {
+	tests := []struct {
+		spec string
+		dpi  float64
+		want int
+		ok   bool
+	}{
+		{"120", 0, 120, true},
+		{"120", 300, 120, true},
+		{"1.5in", 100, 150, true},
+		{"25.4MM", 300, 300, true},
+		{"0.004in", 100, 0, true},
+		{"0.006in", 100, 1, true},
+		{"1in", 0, 0, false},
+		{"12.5", 0, 0, false},
+		{"xin", 100, 0, false},
+	}
+	for _, test := range tests {
+		got, err := ParseLength(test.spec, test.dpi)
+		if (err == nil) != test.ok {
+			t.Errorf("ParseLength(%q, %g) gave error %v", test.spec, test.dpi, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseLength(%q, %g) gave %d, want %d", test.spec, test.dpi, got, test.want)
+		}
+	}
+}
+
+func TestParseCrop(t *testing.T) {
+	tests := []struct {
+		spec                     string
+		dpi                      float64
+		left, top, width, height int
+		ok                       bool
+	}{
+		{"10,20,109,69", 0, 10, 20, 100, 50, true},
+		{"10,20,100x50", 0, 10, 20, 100, 50, true},
+		{"0.1in,0.2in,1inx0.5in", 100, 10, 20, 100, 50, true},
+		{"5,5,5,5", 0, 5, 5, 1, 1, true},
+		//a size which rounds to no pixels at all is an empty crop
+		{"0,0,0.004inx1in", 100, 0, 0, 0, 0, false},
+		{"10,20,9,69", 0, 0, 0, 0, 0, false},
+		{"-1,0,10x10", 0, 0, 0, 0, 0, false},
+		{"0,0,10", 0, 0, 0, 0, 0, false},
+		{"0,0,10x", 0, 0, 0, 0, 0, false},
+		{"1,2,3,4,5", 0, 0, 0, 0, 0, false},
+	}
+	for _, test := range tests {
+		left, top, width, height, err := ParseCrop(test.spec, test.dpi)
+		if (err == nil) != test.ok {
+			t.Errorf("ParseCrop(%q, %g) gave error %v", test.spec, test.dpi, err)
+			continue
+		}
+		if left != test.left || top != test.top || width != test.width || height != test.height {
+			t.Errorf("ParseCrop(%q, %g) gave %d,%d %dx%d, want %d,%d %dx%d", test.spec, test.dpi, left, top, width, height, test.left, test.top, test.width, test.height)
+		}
+	}
+}
+
+func TestParseClip(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Clip
+		ok   bool
+	}{
+		{"start=2s,end=6s,fps=15", Clip{2, 6, 15}, true},
+		{"end=1.5", Clip{0, 1.5, 0}, true},
+		{" start=3 , ", Clip{3, 0, 0}, true},
+		{"", Clip{}, true},
+		{"start=6,end=2", Clip{}, false},
+		{"start=2,end=2", Clip{}, false},
+		{"fps=0", Clip{}, false},
+		{"start=-1", Clip{}, false},
+		{"end=inf", Clip{}, false},
+		{"speed=2", Clip{}, false},
+		{"start", Clip{}, false},
+	}
+	for _, test := range tests {
+		got, err := ParseClip(test.spec)
+		if (err == nil) != test.ok {
+			t.Errorf("ParseClip(%q) gave error %v", test.spec, err)
+			continue
+		}
+		if test.ok && got != test.want {
+			t.Errorf("ParseClip(%q) gave %+v, want %+v", test.spec, got, test.want)
+		}
+	}
+
+	frames := []struct {
+		clip        Clip
+		fps         float64
+		count       int
+		first, last int
+	}{
+		{Clip{2, 6, 0}, 10, 100, 20, 60},
+		{Clip{2, 0, 0}, 10, 100, 20, 100},
+		{Clip{2, 60, 0}, 10, 100, 20, 100},
+		{Clip{20, 60, 0}, 10, 100, 100, 100},
+		{Clip{0.04, 0, 0}, 10, 100, 0, 100},
+		{Clip{1e300, 0, 0}, 10, 100, 100, 100},
+	}
+	for _, test := range frames {
+		if first, last := test.clip.Frames(test.fps, test.count); first != test.first || last != test.last {
+			t.Errorf("%+v over %d frames at %g fps gave frames %d to %d, want %d to %d", test.clip, test.count, test.fps, first, last, test.first, test.last)
+		}
+	}
+}
+
+func TestParsePreset(t *testing.T) {
+	tests := []struct {
+		name string
+		want Preset
+		ok   bool
+	}{
+		{"720p", Preset{1280, 720, false}, true},
+		{"240p", Preset{427, 240, false}, true},
+		{"square-200", Preset{200, 200, true}, true},
+		{"square-0", Preset{}, false},
+		{"square--5", Preset{}, false},
+		{"square-", Preset{}, false},
+		{"720", Preset{}, false},
+		{"4k", Preset{}, false},
+	}
+	for _, test := range tests {
+		got, err := ParsePreset(test.name)
+		if (err == nil) != test.ok {
+			t.Errorf("ParsePreset(%q) gave error %v", test.name, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParsePreset(%q) gave %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+//Interlacing must only reorder the rows so the decoded frames are unchanged. Frames with
+//rows left over from each pass of 8 & their own colour tables are included
+func TestInterlaceGIF(t *testing.T) {
+	var frames []*image.Paletted
+	for _, size := range []image.Point{{13, 11}, {5, 21}, {1, 1}} {
+		frame := image.NewPaletted(image.Rect(0, 0, size.X, size.Y), palette.WebSafe)
+		for j := range frame.Pix {
+			frame.Pix[j] = uint8((j * 7) % len(palette.WebSafe))
+		}
+		frames = append(frames, frame)
+	}
+	for _, globaltable := range []bool{true, false} {
+		var buf bytes.Buffer
+		if err := EncodeGIF(&buf, frames, []int{10, 20, 30}, 0, globaltable); err != nil {
+			t.Fatalf("encoding gif: %s", err)
+		}
+		interlaced, err := InterlaceGIF(buf.Bytes())
+		if err != nil {
+			t.Fatalf("interlacing gif: %s", err)
+		}
+
+		g, err := gif.DecodeAll(bytes.NewReader(interlaced))
+		if err != nil {
+			t.Fatalf("decoding interlaced gif: %s", err)
+		}
+		if len(g.Image) != len(frames) {
+			t.Fatalf("interlaced gif has %d frames, want %d", len(g.Image), len(frames))
+		}
+		for j, frame := range frames {
+			got := g.Image[j]
+			if got.Bounds() != frame.Bounds() {
+				t.Errorf("frame %d is %v after interlacing, want %v", j, got.Bounds(), frame.Bounds())
+				continue
+			}
+			b := frame.Bounds()
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					if got.At(x, y) != frame.At(x, y) {
+						t.Fatalf("frame %d pixel %d,%d is %v after interlacing, want %v", j, x, y, got.At(x, y), frame.At(x, y))
+					}
+				}
+			}
+		}
+
+		//image/gif hides the interlace flag so it is read from each image descriptor
+		n, err := gifHeaderLen(interlaced)
+		if err != nil {
+			t.Fatalf("reading interlaced gif header: %s", err)
+		}
+		descriptors := 0
+		for n < len(interlaced) && interlaced[n] != 0x3b {
+			switch interlaced[n] {
+			case 0x21:
+				_, end, err := subBlocks(interlaced, n+2)
+				if err != nil {
+					t.Fatalf("reading extension: %s", err)
+				}
+				n = end
+			case 0x2c:
+				flags := interlaced[n+9]
+				if flags&0x40 == 0 {
+					t.Errorf("image descriptor %d is not flagged as interlaced", descriptors)
+				}
+				start := n + 10
+				if flags&0x80 != 0 {
+					start += 3 * (1 << (uint(flags&0x07) + 1))
+				}
+				_, end, err := subBlocks(interlaced, start+1)
+				if err != nil {
+					t.Fatalf("reading image data: %s", err)
+				}
+				n = end
+				descriptors++
+			default:
+				t.Fatalf("unexpected block 0x%02x at %d", interlaced[n], n)
+			}
+		}
+		if descriptors != len(frames) {
+			t.Errorf("found %d image descriptors, want %d", descriptors, len(frames))
+		}
+	}
+}
+
+//identityCube returns a .cube file of size entries per channel which maps every colour to
+//itself, with header lines added before the table
+func identityCube(size int, header string) string {
+	cube := header + fmt.Sprintf("LUT_3D_SIZE %d\n", size)
+	for b := 0; b < size; b++ {
+		for g := 0; g < size; g++ {
+			for r := 0; r < size; r++ {
+				s := float64(size - 1)
+				cube += fmt.Sprintf("%g %g %g\n", float64(r)/s, float64(g)/s, float64(b)/s)
+			}
+		}
+	}
+	return cube
+}
+
+func TestLoadCube(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goanigiffy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(cube string) string {
+		filename := filepath.Join(dir, "test.cube")
+		if err := ioutil.WriteFile(filename, []byte(cube), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for j, c := range []color.NRGBA{{0, 0, 0, 255}, {255, 255, 255, 255}, {255, 0, 0, 255}, {12, 200, 99, 255},
+		{1, 2, 3, 128}, {254, 127, 128, 0}, {64, 64, 192, 255}, {33, 66, 99, 255}} {
+		img.SetNRGBA(j%4, j/4, c)
+	}
+	for _, header := range []string{"", "TITLE \"identity\"\n# comment\n", "LUT_3D_INPUT_RANGE 0.0 1.0\n", "DOMAIN_MIN 0 0 0\nDOMAIN_MAX 1 1 1\n"} {
+		for _, size := range []int{2, 5} {
+			lut, err := LoadCube(write(identityCube(size, header)))
+			if err != nil {
+				t.Errorf("LoadCube of an identity LUT of size %d with header %q gave error %s", size, header, err)
+				continue
+			}
+			graded := NormalizeImage(ApplyLUT(lut, img))
+			for y := 0; y < 2; y++ {
+				for x := 0; x < 4; x++ {
+					if got, want := graded.NRGBAAt(x, y), img.NRGBAAt(x, y); got != want {
+						t.Errorf("identity LUT of size %d with header %q turned %v into %v", size, header, want, got)
+					}
+				}
+			}
+		}
+	}
+
+	malformed := map[string]string{
+		"size of 1":            identityCube(1, ""),
+		"size of 257":          "LUT_3D_SIZE 257\n",
+		"size not a number":    "LUT_3D_SIZE two\n0 0 0\n",
+		"no size":              "",
+		"1D LUT":               "LUT_1D_SIZE 2\n0 0 0\n1 1 1\n",
+		"table before size":    "0 0 0\n" + identityCube(2, ""),
+		"too few entries":      strings.TrimSuffix(identityCube(2, ""), "1 1 1\n"),
+		"too many entries":     identityCube(2, "") + "1 1 1\n",
+		"entry of 2 numbers":   strings.Replace(identityCube(2, ""), "1 1 1\n", "1 1\n", 1),
+		"entry not numbers":    strings.Replace(identityCube(2, ""), "1 1 1\n", "1 x 1\n", 1),
+		"input range of 1":     identityCube(2, "LUT_3D_INPUT_RANGE 1\n"),
+		"empty input range":    identityCube(2, "LUT_3D_INPUT_RANGE 1 1\n"),
+		"domain max below min": identityCube(2, "DOMAIN_MIN 0 0 0\nDOMAIN_MAX 1 -1 1\n"),
+		"domain of 2 numbers":  identityCube(2, "DOMAIN_MIN 0 0\n"),
+	}
+	for name, cube := range malformed {
+		if _, err := LoadCube(write(cube)); err == nil {
+			t.Errorf("LoadCube accepted a .cube file with %s", name)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+/*
+   Copyright 2014 Hariharan Srinath
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+//LUT is a 3D colour lookup table as used by video colour grading tools. Table holds an
+//output red, green & blue for each of Size x Size x Size input colours with red changing
+//fastest. Input colours are scaled from the Min to Max domain
+type LUT struct {
+	Size     int
+	Table    [][3]float64
+	Min, Max [3]float64
+}
+
+//LoadCube reads a 3D LUT from a .cube file. Only 3D LUTs with sizes of 2 to 256 are read
+func LoadCube(filename string) (*LUT, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lut := &LUT{Max: [3]float64{1, 1, 1}}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		switch fields[0] {
+		case "TITLE":
+		case "LUT_1D_SIZE":
+			return nil, fmt.Errorf("line %d : only 3D LUTs are supported", line)
+		case "LUT_3D_SIZE":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d : LUT_3D_SIZE needs one value", line)
+			}
+			if lut.Size, err = strconv.Atoi(fields[1]); err != nil || lut.Size < 2 || lut.Size > 256 {
+				return nil, fmt.Errorf("line %d : LUT_3D_SIZE must be between 2 and 256", line)
+			}
+		case "DOMAIN_MIN", "DOMAIN_MAX":
+			v, err := parseTriple(fields[1:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d : %s", line, err)
+			}
+			if fields[0] == "DOMAIN_MIN" {
+				lut.Min = v
+			} else {
+				lut.Max = v
+			}
+		case "LUT_3D_INPUT_RANGE":
+			//Resolve & other exporters write the same range for all channels this way
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("line %d : LUT_3D_INPUT_RANGE needs a min & a max", line)
+			}
+			min, errmin := strconv.ParseFloat(fields[1], 64)
+			max, errmax := strconv.ParseFloat(fields[2], 64)
+			if errmin != nil || errmax != nil {
+				return nil, fmt.Errorf("line %d : invalid LUT_3D_INPUT_RANGE", line)
+			}
+			lut.Min, lut.Max = [3]float64{min, min, min}, [3]float64{max, max, max}
+		default:
+			if lut.Size == 0 {
+				return nil, fmt.Errorf("line %d : LUT_3D_SIZE must come before the table", line)
+			}
+			v, err := parseTriple(fields)
+			if err != nil {
+				return nil, fmt.Errorf("line %d : %s", line, err)
+			}
+			lut.Table = append(lut.Table, v)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if lut.Size == 0 {
+		return nil, fmt.Errorf("no LUT_3D_SIZE found")
+	}
+	if want := lut.Size * lut.Size * lut.Size; len(lut.Table) != want {
+		return nil, fmt.Errorf("found %d table entries instead of %d for size %d", len(lut.Table), want, lut.Size)
+	}
+	for c := 0; c < 3; c++ {
+		if lut.Max[c] <= lut.Min[c] {
+			return nil, fmt.Errorf("DOMAIN_MAX or the LUT_3D_INPUT_RANGE max must be above the min")
+		}
+	}
+	return lut, nil
+}
+
+//parseTriple parses three numbers like "0.1 0.5 1"
+func parseTriple(fields []string) ([3]float64, error) {
+	var v [3]float64
+	if len(fields) != 3 {
+		return v, fmt.Errorf("expected 3 numbers but found %d", len(fields))
+	}
+	for c, field := range fields {
+		var err error
+		if v[c], err = strconv.ParseFloat(field, 64); err != nil {
+			return v, fmt.Errorf("invalid number %q", field)
+		}
+	}
+	return v, nil
+}
+
+//Lookup returns the output red, green & blue (0-1) for an input colour, interpolating
+//trilinearly between the nearest table entries
+func (lut *LUT) Lookup(r, g, b float64) [3]float64 {
+	in := [3]float64{r, g, b}
+	var lo, hi [3]int
+	var t [3]float64
+	for c := range in {
+		x := (in[c] - lut.Min[c]) / (lut.Max[c] - lut.Min[c]) * float64(lut.Size-1)
+		x = math.Max(0, math.Min(float64(lut.Size-1), x))
+		lo[c] = int(x)
+		hi[c] = lo[c] + 1
+		if hi[c] >= lut.Size {
+			hi[c] = lut.Size - 1
+		}
+		t[c] = x - float64(lo[c])
+	}
+	entry := func(ri, gi, bi int) [3]float64 {
+		return lut.Table[ri+lut.Size*(gi+lut.Size*bi)]
+	}
+	var out [3]float64
+	for c := 0; c < 3; c++ {
+		c00 := entry(lo[0], lo[1], lo[2])[c]*(1-t[0]) + entry(hi[0], lo[1], lo[2])[c]*t[0]
+		c10 := entry(lo[0], hi[1], lo[2])[c]*(1-t[0]) + entry(hi[0], hi[1], lo[2])[c]*t[0]
+		c01 := entry(lo[0], lo[1], hi[2])[c]*(1-t[0]) + entry(hi[0], lo[1], hi[2])[c]*t[0]
+		c11 := entry(lo[0], hi[1], hi[2])[c]*(1-t[0]) + entry(hi[0], hi[1], hi[2])[c]*t[0]
+		c0 := c00*(1-t[1]) + c10*t[1]
+		c1 := c01*(1-t[1]) + c11*t[1]
+		out[c] = c0*(1-t[2]) + c1*t[2]
+	}
+	return out
+}
+
+//ApplyLUT colour grades img with lut. Alpha is left alone
+func ApplyLUT(lut *LUT, img image.Image) image.Image {
+	dst := imaging.Clone(img)
+	//Frames usually repeat colours a lot so each distinct colour is only looked up once
+	cache := make(map[[3]uint8][3]uint8)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		key := [3]uint8{dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2]}
+		out, ok := cache[key]
+		if !ok {
+			v := lut.Lookup(float64(key[0])/255, float64(key[1])/255, float64(key[2])/255)
+			for c := range out {
+				out[c] = uint8(math.Max(0, math.Min(255, v[c]*255+0.5)))
+			}
+			cache[key] = out
+		}
+		copy(dst.Pix[i:i+3], out[:])
+	}
+	return dst
+}
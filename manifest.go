@@ -0,0 +1,98 @@
+/*
+   Copyright 2014 Hariharan Srinath
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//A manifest records what went into a GIF, the content hash of every source image & the
+//settings used, so that a rebuild can be skipped when neither has changed
+
+//manifestIgnored are the flags which don't change the GIF written & so are left out of
+//the manifest
+var manifestIgnored = map[string]bool{
+	"hashmanifest":  true,
+	"skipunchanged": true,
+	"threads":       true,
+	"timing":        true,
+	"timingcsv":     true,
+	"verbose":       true,
+	"verify":        true,
+}
+
+//BuildManifest returns the manifest for a GIF made from filenames with the current flags.
+//Each setting is on a line like "setting -delay=3" followed by a line with the SHA-256 of
+//each source image & its name, then a line listing the frames made from it since the
+//frames of an animated GIF all share one file
+func BuildManifest(filenames []string) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "goanigiffy manifest\n")
+	flag.VisitAll(func(f *flag.Flag) {
+		if !manifestIgnored[f.Name] {
+			fmt.Fprintf(&buf, "setting -%s=%s\n", f.Name, f.Value)
+		}
+	})
+	for _, filename := range flag.Args() {
+		fmt.Fprintf(&buf, "arg %s\n", filename)
+	}
+	var distinct []string
+	frames := make(map[string][]string)
+	for j, filename := range filenames {
+		if frames[filename] == nil {
+			distinct = append(distinct, filename)
+		}
+		frames[filename] = append(frames[filename], strconv.Itoa(j))
+	}
+	for _, filename := range distinct {
+		sum, err := fileHash(filename)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, "%s  %s\n", sum, filename)
+		fmt.Fprintf(&buf, "frames %s\n", strings.Join(frames[filename], ","))
+	}
+	return buf.Bytes(), nil
+}
+
+//fileHash returns the hex SHA-256 of the contents of filename
+func fileHash(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+//ManifestUnchanged reports whether the manifest stored in filename matches manifest
+func ManifestUnchanged(filename string, manifest []byte) bool {
+	stored, err := ioutil.ReadFile(filename)
+	return err == nil && bytes.Equal(stored, manifest)
+}
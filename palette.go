@@ -0,0 +1,107 @@
+/*
+   Copyright 2014 Hariharan Srinath
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+//ParseHexColor parses a colour written as RRGGBB or #RRGGBB (or the short forms RGB/#RGB)
+func ParseHexColor(s string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return color.NRGBA{}, fmt.Errorf("invalid hex colour %q", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid hex colour %q", s)
+	}
+	return color.NRGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
+
+//LoadPalette reads a palette file of hex colours. The file may either be a JSON array of
+//strings like ["#000000", "#ffffff"] or plain text with colours separated by whitespace
+//or commas. Palettes must have between 2 and 256 colours to be usable in a GIF
+func LoadPalette(filename string) (color.Palette, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []string
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, fmt.Errorf("error parsing JSON palette: %s", err)
+		}
+	} else {
+		entries = strings.FieldsFunc(string(data), func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+		})
+	}
+
+	var pal color.Palette
+	for _, entry := range entries {
+		c, err := ParseHexColor(entry)
+		if err != nil {
+			return nil, err
+		}
+		pal = append(pal, c)
+	}
+
+	if len(pal) < 2 || len(pal) > 256 {
+		return nil, fmt.Errorf("palette has %d colours, it must have between 2 and 256", len(pal))
+	}
+	return pal, nil
+}
+
+//swatchSize is the size in pixels of each colour in a palette swatch image
+const swatchSize = 16
+
+//SavePalette writes pal to filename. A .png, .jpg or .jpeg file gets a swatch image with
+//16 colours to a row while any other file gets the colours as hex text, one to a line, which
+//can be read back with -palettefile
+func SavePalette(filename string, pal color.Palette) error {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".png", ".jpg", ".jpeg":
+		rows := (len(pal) + 15) / 16
+		swatch := imaging.New(16*swatchSize, rows*swatchSize, color.Transparent)
+		for j, c := range pal {
+			x, y := (j%16)*swatchSize, (j/16)*swatchSize
+			swatch = imaging.Paste(swatch, imaging.New(swatchSize, swatchSize, c), image.Pt(x, y))
+		}
+		return imaging.Save(swatch, filename)
+	}
+	var buf bytes.Buffer
+	for _, c := range pal {
+		nc := color.NRGBAModel.Convert(c).(color.NRGBA)
+		fmt.Fprintf(&buf, "#%02x%02x%02x\n", nc.R, nc.G, nc.B)
+	}
+	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
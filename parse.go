@@ -0,0 +1,387 @@
+/*
+   Copyright 2014 Hariharan Srinath
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//Clip describes a section of a video frame sequence given to the -clip flag. Start & End
+//are in seconds with an End of 0 meaning the end of the sequence. FPS is the rate at which
+//the frames were grabbed & is also used as the playback rate. An FPS of 0 means unspecified
+type Clip struct {
+	Start, End, FPS float64
+}
+
+//ParseClip parses a clip spec like "start=2s,end=6s,fps=15". All keys are optional and the
+//s suffix on start & end is optional
+func ParseClip(spec string) (Clip, error) {
+	var clip Clip
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return clip, fmt.Errorf("invalid clip setting %q, expected key=value", part)
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if key == "start" || key == "end" {
+			value = strings.TrimSuffix(value, "s")
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil || v < 0 || math.IsInf(v, 0) || math.IsNaN(v) {
+			return clip, fmt.Errorf("invalid value %q for clip setting %s", kv[1], key)
+		}
+		switch key {
+		case "start":
+			clip.Start = v
+		case "end":
+			clip.End = v
+		case "fps":
+			if v == 0 {
+				return clip, fmt.Errorf("clip fps must be greater than 0")
+			}
+			clip.FPS = v
+		default:
+			return clip, fmt.Errorf("unknown clip setting %q, valid settings are start, end & fps", key)
+		}
+	}
+	if clip.End != 0 && clip.End <= clip.Start {
+		return clip, fmt.Errorf("clip end %gs must be after start %gs", clip.End, clip.Start)
+	}
+	return clip, nil
+}
+
+//Frames returns the range of frame indexes [first, last) covered by the clip out of count
+//frames grabbed at fps frames per second. Both are kept within 0 to count
+func (c Clip) Frames(fps float64, count int) (first, last int) {
+	first = clipFrame(c.Start*fps, count)
+	last = count
+	if c.End != 0 {
+		last = clipFrame(c.End*fps, count)
+	}
+	return first, last
+}
+
+//clipFrame rounds the frame position f to the nearest frame within 0 to count. The bounds
+//are checked before converting since a huge or infinite f doesn't fit in an int
+func clipFrame(f float64, count int) int {
+	if !(f >= 0) {
+		return 0
+	}
+	if f+0.5 >= float64(count) {
+		return count
+	}
+	return int(f + 0.5)
+}
+
+//Preset is an output size expanded from the -preset flag. Frames are shrunk to fit within
+//Width x Height keeping their aspect ratio. If Pad is set, they are also letterboxed onto
+//a canvas of exactly Width x Height
+type Preset struct {
+	Width, Height int
+	Pad           bool
+}
+
+//presetHeights are the video style presets which fit frames in a 16:9 box of that height
+var presetHeights = map[string]int{
+	"240p":  240,
+	"360p":  360,
+	"480p":  480,
+	"720p":  720,
+	"1080p": 1080,
+}
+
+//ParsePreset expands a preset name. Valid names are 240p, 360p, 480p, 720p, 1080p which
+//fit frames within a 16:9 box of that height & square-N which letterboxes frames onto an
+//N x N square canvas
+func ParsePreset(name string) (Preset, error) {
+	if h, ok := presetHeights[name]; ok {
+		return Preset{Width: (h*16 + 8) / 9, Height: h}, nil
+	}
+	if strings.HasPrefix(name, "square-") {
+		n, err := strconv.Atoi(strings.TrimPrefix(name, "square-"))
+		if err != nil || n <= 0 {
+			return Preset{}, fmt.Errorf("invalid square preset %q, expected square-N with N a positive number of pixels", name)
+		}
+		return Preset{Width: n, Height: n, Pad: true}, nil
+	}
+	return Preset{}, fmt.Errorf("unknown preset %q, valid presets are 240p, 360p, 480p, 720p, 1080p & square-N", name)
+}
+
+//ParseLength parses a length in pixels like "120" or, when dpi is above 0, a physical length
+//in inches or millimetres like "1.5in" or "40mm" which is converted to the nearest pixel
+func ParseLength(spec string, dpi float64) (int, error) {
+	value := strings.TrimSpace(strings.ToLower(spec))
+	perunit := 0.0
+	switch {
+	case strings.HasSuffix(value, "in"):
+		perunit, value = dpi, strings.TrimSuffix(value, "in")
+	case strings.HasSuffix(value, "mm"):
+		perunit, value = dpi/25.4, strings.TrimSuffix(value, "mm")
+	default:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("not a whole number of pixels")
+		}
+		return n, nil
+	}
+	if dpi <= 0 {
+		return 0, fmt.Errorf("inches & millimetres need -dpi to convert them to pixels")
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0, fmt.Errorf("not a number of inches or millimetres")
+	}
+	return int(math.Floor(v*perunit + 0.5)), nil
+}
+
+//ParseCrop parses a crop rectangle given either as "left,top,right,bottom" with right &
+//bottom being the last column & row included or as "left,top,WxH". It returns the crop as
+//the left, top, width & height used by the individual crop flags. With a dpi above 0 the
+//values can also be in inches or millimetres like "0.5in,0.5in,3inx2in"
+func ParseCrop(spec string, dpi float64) (left, top, width, height int, err error) {
+	parts := strings.Split(spec, ",")
+	nums := make([]int, 0, 4)
+	for j, part := range parts {
+		part = strings.TrimSpace(part)
+		if j == 2 && len(parts) == 3 {
+			wh := strings.SplitN(strings.ToLower(part), "x", 2)
+			if len(wh) != 2 {
+				return 0, 0, 0, 0, fmt.Errorf("invalid crop size %q, expected WxH", part)
+			}
+			if width, err = ParseLength(wh[0], dpi); err != nil {
+				return 0, 0, 0, 0, fmt.Errorf("invalid crop width %q : %s", wh[0], err)
+			}
+			if height, err = ParseLength(wh[1], dpi); err != nil {
+				return 0, 0, 0, 0, fmt.Errorf("invalid crop height %q : %s", wh[1], err)
+			}
+			continue
+		}
+		n, err := ParseLength(part, dpi)
+		if err != nil {
+			return 0, 0, 0, 0, fmt.Errorf("invalid crop co-ordinate %q : %s", part, err)
+		}
+		nums = append(nums, n)
+	}
+
+	switch len(parts) {
+	case 3:
+		left, top = nums[0], nums[1]
+	case 4:
+		left, top = nums[0], nums[1]
+		width, height = nums[2]-left+1, nums[3]-top+1
+	default:
+		return 0, 0, 0, 0, fmt.Errorf("invalid crop %q, expected left,top,right,bottom or left,top,WxH", spec)
+	}
+
+	if left < 0 || top < 0 {
+		return 0, 0, 0, 0, fmt.Errorf("crop %q starts at a negative co-ordinate", spec)
+	}
+	if width <= 0 || height <= 0 {
+		return 0, 0, 0, 0, fmt.Errorf("crop %q is empty", spec)
+	}
+	return left, top, width, height, nil
+}
+
+//ParseDelays parses a comma separated list of frame delays in hundredths of a second like
+//"10,3,3,3,10". A single value gives every frame the same delay
+func ParseDelays(spec string) ([]int, error) {
+	var delays []int
+	for _, field := range strings.Split(spec, ",") {
+		delay, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("delay %q is not a whole number", field)
+		}
+		if delay < 0 {
+			return nil, fmt.Errorf("delay %d is negative", delay)
+		}
+		delays = append(delays, delay)
+	}
+	return delays, nil
+}
+
+//ParseScale parses a scaling factor given either as a number like "0.5" or as a percentage
+//like "50%". Both of those halve the size of images
+func ParseScale(spec string) (float64, error) {
+	spec = strings.TrimSpace(spec)
+	percent := strings.HasSuffix(spec, "%")
+	scale, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(spec, "%")), 64)
+	if err != nil {
+		return 0, fmt.Errorf("scale %q should be a number like 0.5 or a percentage like 50%%", spec)
+	}
+	if percent {
+		scale /= 100
+	}
+	if scale <= 0 {
+		return 0, fmt.Errorf("scale %q must be greater than 0", spec)
+	}
+	return scale, nil
+}
+
+//ParseGrid parses a grid of tiles like "4x8" into its rows & columns
+func ParseGrid(spec string) (rows, cols int, err error) {
+	parts := strings.Split(strings.ToLower(spec), "x")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("grid %q should be rows x columns like 4x8", spec)
+	}
+	if rows, err = strconv.Atoi(strings.TrimSpace(parts[0])); err != nil {
+		return 0, 0, fmt.Errorf("grid %q has invalid rows", spec)
+	}
+	if cols, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil {
+		return 0, 0, fmt.Errorf("grid %q has invalid columns", spec)
+	}
+	if rows <= 0 || cols <= 0 {
+		return 0, 0, fmt.Errorf("grid %q must have at least 1 row & column", spec)
+	}
+	return rows, cols, nil
+}
+
+//Repeat is a range of frames from First to Last inclusive which is played Times times over
+type Repeat struct {
+	First, Last, Times int
+}
+
+//ParseRepeats parses a comma separated list of frames or frame ranges to repeat like
+//"3-5x2,8x3" which plays frames 3 to 5 twice & frame 8 three times. Ranges must not overlap
+func ParseRepeats(spec string) ([]Repeat, error) {
+	var repeats []Repeat
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		parts := strings.Split(strings.ToLower(field), "x")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("repeat %q should be a frame or range & a count like 8x3 or 3-5x2", field)
+		}
+		var r Repeat
+		var err error
+		if r.Times, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil || r.Times < 1 {
+			return nil, fmt.Errorf("repeat %q needs a count of 1 or more", field)
+		}
+		bounds := strings.SplitN(parts[0], "-", 2)
+		if r.First, err = strconv.Atoi(strings.TrimSpace(bounds[0])); err != nil || r.First < 0 {
+			return nil, fmt.Errorf("repeat %q has an invalid frame", field)
+		}
+		r.Last = r.First
+		if len(bounds) == 2 {
+			if r.Last, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil || r.Last < r.First {
+				return nil, fmt.Errorf("repeat %q has an invalid range", field)
+			}
+		}
+		for _, other := range repeats {
+			if r.First <= other.Last && other.First <= r.Last {
+				return nil, fmt.Errorf("repeat %q overlaps another range", field)
+			}
+		}
+		repeats = append(repeats, r)
+	}
+	return repeats, nil
+}
+
+//ParseAspect parses an aspect ratio like "16:9" or "1:1" into its width & height parts
+func ParseAspect(spec string) (width, height int, err error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("aspect ratio %q should be like 16:9", spec)
+	}
+	if width, err = strconv.Atoi(strings.TrimSpace(parts[0])); err != nil {
+		return 0, 0, fmt.Errorf("aspect ratio %q has an invalid width", spec)
+	}
+	if height, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil {
+		return 0, 0, fmt.Errorf("aspect ratio %q has an invalid height", spec)
+	}
+	if width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("aspect ratio %q must be positive", spec)
+	}
+	return width, height, nil
+}
+
+//defaultOrder is the order image operations are applied in unless -order says otherwise
+var defaultOrder = []string{"crop", "scale", "rotate", "flip"}
+
+//ParseOrder parses a comma separated order of image operations like "rotate,crop". Each
+//operation may appear at most once & operations left out follow in their default order
+func ParseOrder(spec string) ([]string, error) {
+	var order []string
+	seen := map[string]bool{}
+	for _, op := range strings.Split(spec, ",") {
+		op = strings.TrimSpace(op)
+		if op == "" {
+			continue
+		}
+		valid := false
+		for _, known := range defaultOrder {
+			if op == known {
+				valid = true
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown operation %q, valid operations are %s", op, strings.Join(defaultOrder, ", "))
+		}
+		if seen[op] {
+			return nil, fmt.Errorf("operation %q appears more than once", op)
+		}
+		seen[op] = true
+		order = append(order, op)
+	}
+	for _, op := range defaultOrder {
+		if !seen[op] {
+			order = append(order, op)
+		}
+	}
+	return order, nil
+}
+
+//LoadCropPath reads a crop path file with the "left,top" offset of the crop for each frame
+//on its own line. Blank lines reuse the offset of the line before so the window stays put
+func LoadCropPath(filename string) ([]image.Point, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var path []image.Point
+	for j, line := range strings.Split(strings.TrimRight(string(data), "\r\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if len(path) == 0 {
+				return nil, fmt.Errorf("line %d: the first line needs an offset", j+1)
+			}
+			path = append(path, path[len(path)-1])
+			continue
+		}
+		xy := strings.Split(line, ",")
+		if len(xy) != 2 {
+			return nil, fmt.Errorf("line %d: expected left,top but found %q", j+1, line)
+		}
+		x, errx := strconv.Atoi(strings.TrimSpace(xy[0]))
+		y, erry := strconv.Atoi(strings.TrimSpace(xy[1]))
+		if errx != nil || erry != nil || x < 0 || y < 0 {
+			return nil, fmt.Errorf("line %d: invalid offset %q", j+1, line)
+		}
+		path = append(path, image.Pt(x, y))
+	}
+	if len(path) == 0 {
+		return nil, fmt.Errorf("no offsets found")
+	}
+	return path, nil
+}
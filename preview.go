@@ -0,0 +1,107 @@
+/*
+   Copyright 2014 Hariharan Srinath
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"html/template"
+	"image"
+	"os"
+	"path/filepath"
+)
+
+//previewTemplate is the HTML preview page. The GIF is embedded as a data URI so the page can
+//be sent on its own
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+img { max-width: 100%; border: 1px solid #ccc; }
+td { padding: 0.2em 1em 0.2em 0; vertical-align: top; }
+code { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<p><img src="{{.Data}}" alt="{{.Name}}"></p>
+<h2>Summary</h2>
+<table>
+<tr><td>Frames</td><td>{{.Frames}}</td></tr>
+<tr><td>Size</td><td>{{.Width}} x {{.Height}}</td></tr>
+<tr><td>Duration</td><td>{{printf "%.2f" .Duration}} s</td></tr>
+<tr><td>File size</td><td>{{.Bytes}} bytes</td></tr>
+</table>
+<h2>Parameters</h2>
+{{if .Settings}}<table>
+{{range .Settings}}<tr><td><code>-{{.Name}}</code></td><td><code>{{.Value}}</code></td></tr>
+{{end}}</table>{{else}}<p>All parameters were left at their defaults.</p>{{end}}
+{{if .Args}}<h2>Source images</h2>
+<ul>
+{{range .Args}}<li><code>{{.}}</code></li>
+{{end}}</ul>{{end}}
+</body>
+</html>
+`))
+
+//previewSetting is a flag set on the command line
+type previewSetting struct {
+	Name, Value string
+}
+
+//WritePreview writes a self contained HTML page to filename showing the GIF encoded from
+//frames with the given delays & written as gifname, along with a summary of it & the
+//parameters that were set to make it
+func WritePreview(filename, gifname string, encoded []byte, frames []*image.Paletted, delays []int) error {
+	page := struct {
+		Name                  string
+		Data                  template.URL
+		Frames, Width, Height int
+		Duration              float64
+		Bytes                 int
+		Settings              []previewSetting
+		Args                  []string
+	}{
+		Name:   filepath.Base(gifname),
+		Data:   template.URL("data:image/gif;base64," + base64.StdEncoding.EncodeToString(encoded)),
+		Frames: len(frames),
+		Bytes:  len(encoded),
+		Args:   flag.Args(),
+	}
+	if len(frames) > 0 {
+		page.Width, page.Height = frames[0].Bounds().Dx(), frames[0].Bounds().Dy()
+	}
+	for _, d := range delays {
+		page.Duration += float64(d) / 100
+	}
+	flag.Visit(func(f *flag.Flag) {
+		page.Settings = append(page.Settings, previewSetting{f.Name, f.Value.String()})
+	})
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := previewTemplate.Execute(f, page); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
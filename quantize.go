@@ -0,0 +1,222 @@
+/*
+   Copyright 2014 Hariharan Srinath
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+//colorBox is a box of colours in the histogram for median cut. Colours are reduced to 5
+//bits per channel
+type colorBox struct {
+	colors []histColor
+	count  int
+}
+
+//histColor is a 5 bit per channel colour & the number of pixels with it
+type histColor struct {
+	c     [3]uint8
+	count int
+}
+
+//longestAxis returns the channel the box spans the most & how far it spans
+func (box *colorBox) longestAxis() (axis, span int) {
+	for c := 0; c < 3; c++ {
+		lo, hi := uint8(255), uint8(0)
+		for _, hc := range box.colors {
+			if hc.c[c] < lo {
+				lo = hc.c[c]
+			}
+			if hc.c[c] > hi {
+				hi = hc.c[c]
+			}
+		}
+		if int(hi)-int(lo) > span {
+			axis, span = c, int(hi)-int(lo)
+		}
+	}
+	return axis, span
+}
+
+//MedianCutPalette picks a palette of up to n colours suited to img with the median cut
+//algorithm. The colours are repeatedly split into two boxes at the median of their widest
+//channel until there are n boxes & each box gives the average of its colours. Images with
+//fewer than n distinct colours get a smaller palette
+func MedianCutPalette(img image.Image, n int) color.Palette {
+	src := imaging.Clone(img)
+	counts := make(map[[3]uint8]int)
+	for i := 0; i < len(src.Pix); i += 4 {
+		counts[[3]uint8{src.Pix[i] >> 3, src.Pix[i+1] >> 3, src.Pix[i+2] >> 3}]++
+	}
+	box := &colorBox{}
+	for c, count := range counts {
+		box.colors = append(box.colors, histColor{c, count})
+		box.count += count
+	}
+	boxes := []*colorBox{box}
+
+	for len(boxes) < n {
+		//Split the box with the most pixels that still has more than one colour
+		best := -1
+		for j, b := range boxes {
+			if len(b.colors) > 1 && (best < 0 || b.count > boxes[best].count) {
+				best = j
+			}
+		}
+		if best < 0 {
+			break
+		}
+		b := boxes[best]
+		axis, _ := b.longestAxis()
+		sort.Slice(b.colors, func(i, j int) bool { return b.colors[i].c[axis] < b.colors[j].c[axis] })
+		half, split := 0, 1
+		for j, hc := range b.colors[:len(b.colors)-1] {
+			half += hc.count
+			split = j + 1
+			if half*2 >= b.count {
+				break
+			}
+		}
+		lower := &colorBox{colors: b.colors[:split], count: half}
+		upper := &colorBox{colors: b.colors[split:], count: b.count - half}
+		boxes[best] = lower
+		boxes = append(boxes, upper)
+	}
+
+	pal := make(color.Palette, 0, len(boxes))
+	for _, b := range boxes {
+		var sum [3]int
+		for _, hc := range b.colors {
+			for c := range sum {
+				sum[c] += int(hc.c[c]) * hc.count
+			}
+		}
+		if b.count == 0 {
+			continue
+		}
+		var avg [3]uint8
+		for c := range sum {
+			//scale the 5 bit average back up to 8 bits so black & white stay exact
+			avg[c] = uint8((sum[c]*255 + 31*b.count/2) / (31 * b.count))
+		}
+		pal = append(pal, color.RGBA{avg[0], avg[1], avg[2], 0xff})
+	}
+	return pal
+}
+
+//ExactPalette returns the distinct colours of img as a palette if there are at most n of
+//them, in which case the frame can be converted without losing any colour
+func ExactPalette(img image.Image, n int) (color.Palette, bool) {
+	src := imaging.Clone(img)
+	seen := make(map[color.NRGBA]bool)
+	var pal color.Palette
+	for i := 0; i < len(src.Pix); i += 4 {
+		c := color.NRGBA{src.Pix[i], src.Pix[i+1], src.Pix[i+2], src.Pix[i+3]}
+		if seen[c] {
+			continue
+		}
+		if len(pal) == n {
+			return nil, false
+		}
+		seen[c] = true
+		pal = append(pal, c)
+	}
+	return pal, true
+}
+
+//HasPartialAlpha reports whether any pixel of img is neither fully transparent nor fully
+//opaque, such as the soft edges of a cut out subject, which a GIF can't hold
+func HasPartialAlpha(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return false
+	}
+	src := imaging.Clone(img)
+	for i := 3; i < len(src.Pix); i += 4 {
+		if src.Pix[i] != 0 && src.Pix[i] != 0xff {
+			return true
+		}
+	}
+	return false
+}
+
+//qualityLevel is the palette & dithering used by a -quality level. A levels above 0 uses a
+//uniform palette with that many levels per channel, a colors above 0 picks that many colours
+//for each frame with median cut & otherwise the Plan9 palette is used
+type qualityLevel struct {
+	levels int
+	colors int
+	dither string
+}
+
+//qualityLevels trades speed & size for fidelity from level 1 to 10. Level 5 is the same as
+//the default flags
+var qualityLevels = [...]qualityLevel{
+	1:  {levels: 3, dither: "none"},
+	2:  {levels: 4, dither: "none"},
+	3:  {levels: 4, dither: "bayer"},
+	4:  {dither: "bayer"},
+	5:  {dither: "floydsteinberg"},
+	6:  {colors: 64, dither: "floydsteinberg"},
+	7:  {colors: 96, dither: "floydsteinberg"},
+	8:  {colors: 128, dither: "floydsteinberg"},
+	9:  {colors: 192, dither: "floydsteinberg"},
+	10: {colors: 256, dither: "floydsteinberg"},
+}
+
+//StabilizePalettes stops the colours of palettes picked for each frame shimmering when
+//noise in otherwise similar frames shifts them slightly. Every colour of a palette that is
+//within tolerance on each channel of a colour of the palette before it is replaced by that
+//colour so it stays put. Palettes marked exact hold a frame's own colours & are left alone.
+//It returns how many colours were replaced
+func StabilizePalettes(tolerance int, pals []color.Palette, exact []bool) int {
+	replaced := 0
+	var prev color.Palette
+	for j, pal := range pals {
+		if exact[j] {
+			continue
+		}
+		for k, c := range pal {
+			if match := nearbyColor(tolerance, prev, c); match != nil {
+				pal[k] = match
+				replaced++
+			}
+		}
+		prev = pal
+	}
+	return replaced
+}
+
+//nearbyColor returns the colour of pal closest to c if it is within tolerance on each
+//channel or nil if there is none
+func nearbyColor(tolerance int, pal color.Palette, c color.Color) color.Color {
+	if len(pal) == 0 {
+		return nil
+	}
+	match := pal[pal.Index(c)]
+	r1, g1, b1, a1 := c.RGBA()
+	r2, g2, b2, a2 := match.RGBA()
+	for _, d := range []int{int(r1>>8) - int(r2>>8), int(g1>>8) - int(g2>>8), int(b1>>8) - int(b2>>8), int(a1>>8) - int(a2>>8)} {
+		if d > tolerance || d < -tolerance {
+			return nil
+		}
+	}
+	return match
+}
@@ -0,0 +1,96 @@
+/*
+   Copyright 2014 Hariharan Srinath
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+)
+
+//A recipe is a JSON object of the settings for a GIF, eg. {"delay": "5", "scale": "0.5"}, so
+//that a known good set of settings can be saved & reused with other source images
+
+//recipeIgnored are the flags left out of a recipe since they name the files of one
+//particular run, either the sources & the files made from them or the files handling recipes
+//themselves. Reusing them with other sources would overwrite the last run's outputs
+var recipeIgnored = map[string]bool{
+	"croppath":     true,
+	"dest":         true,
+	"dumppalette":  true,
+	"fuse":         true,
+	"hashmanifest": true,
+	"htmlpreview":  true,
+	"loadrecipe":   true,
+	"poster":       true,
+	"saverecipe":   true,
+	"spritesheet":  true,
+	"src":          true,
+	"thumb":        true,
+	"timestamps":   true,
+	"timingcsv":    true,
+	"timingfile":   true,
+	"version":      true,
+}
+
+//SaveRecipe writes the flags given on the command line or by a loaded recipe to filename.
+//Flags left at their defaults are not written so they also stay at their defaults when the
+//recipe is loaded
+func SaveRecipe(filename string) error {
+	recipe := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		if !recipeIgnored[f.Name] {
+			recipe[f.Name] = f.Value.String()
+		}
+	})
+	data, err := json.MarshalIndent(recipe, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+//LoadRecipe sets the flags in the recipe filename as though they were given on the command
+//line. Flags actually given on the command line take precedence over the recipe
+func LoadRecipe(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var recipe map[string]string
+	if err := json.Unmarshal(data, &recipe); err != nil {
+		return err
+	}
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for name, value := range recipe {
+		if recipeIgnored[name] {
+			return fmt.Errorf("%s cannot be set by a recipe", name)
+		}
+		if given[name] {
+			continue
+		}
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf("unknown setting %s", name)
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("setting %s : %s", name, err)
+		}
+	}
+	return nil
+}
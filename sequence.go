@@ -0,0 +1,370 @@
+/*
+   Copyright 2014 Hariharan Srinath
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"image"
+	"log"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+//This file holds effects which work across the whole sequence of processed frames rather
+//than on one image at a time. They run after all images are processed & before quantizing
+
+//BlendImages returns a mix of a & b with b given the weight w (0-1). Both images must have
+//the same bounds
+func BlendImages(a, b image.Image, w float64) *image.NRGBA {
+	dst := imaging.Clone(a)
+	src := imaging.Clone(b)
+	for i := range dst.Pix {
+		dst.Pix[i] = uint8(float64(dst.Pix[i])*(1-w) + float64(src.Pix[i])*w + 0.5)
+	}
+	return dst
+}
+
+//Interpolate smooths choppy sequences by inserting factor frames blended between each pair
+//of consecutive frames. It also returns for every resulting frame the index of the frame it
+//was made from or follows so that delays & source names can be spread over the new frames.
+//No frames are inserted between frames of different sizes
+func Interpolate(factor int, frames []image.Image, verbose bool) ([]image.Image, []int) {
+	if factor <= 0 {
+		return frames, identityOrder(len(frames))
+	}
+	if verbose {
+		log.Printf("Interpolating %d frames between each of %d frames", factor, len(frames))
+	}
+	var interpolated []image.Image
+	var origin []int
+	for j, frame := range frames {
+		interpolated = append(interpolated, frame)
+		origin = append(origin, j)
+		if j+1 == len(frames) || !frame.Bounds().Eq(frames[j+1].Bounds()) {
+			continue
+		}
+		for k := 1; k <= factor; k++ {
+			interpolated = append(interpolated, BlendImages(frame, frames[j+1], float64(k)/float64(factor+1)))
+			origin = append(origin, j)
+		}
+	}
+	return interpolated, origin
+}
+
+//MotionBlur blends each frame with the blurred frame before it giving the preceding frames
+//a weight of strength (0-1). Older frames fade out geometrically so fast motion leaves a
+//short trail. A strength of 0 is a no-op. Frames of a different size to the one before
+//them are left as is
+func MotionBlur(strength float64, frames []image.Image, verbose bool) []image.Image {
+	if strength <= 0 {
+		return frames
+	}
+	if verbose {
+		log.Printf("Applying motion blur with strength %g to %d frames", strength, len(frames))
+	}
+	blurred := make([]image.Image, len(frames))
+	for j, frame := range frames {
+		if j == 0 || !frame.Bounds().Eq(blurred[j-1].Bounds()) {
+			blurred[j] = frame
+			continue
+		}
+		blurred[j] = BlendImages(frame, blurred[j-1], strength)
+	}
+	return blurred
+}
+
+//Overlap ghosts a fraction of the next frame into each frame to smooth choppy motion without
+//adding frames like Interpolate does. The next frame is given the weight amount (0-1) & an
+//amount of 0 is a no-op. The last frame & frames of a different size to the next frame are
+//left as is
+func Overlap(amount float64, frames []image.Image, verbose bool) []image.Image {
+	if amount <= 0 {
+		return frames
+	}
+	if verbose {
+		log.Printf("Overlapping %d frames with %g of the next frame", len(frames), amount)
+	}
+	overlapped := make([]image.Image, len(frames))
+	for j, frame := range frames {
+		if j+1 == len(frames) || !frame.Bounds().Eq(frames[j+1].Bounds()) {
+			overlapped[j] = frame
+			continue
+		}
+		overlapped[j] = BlendImages(frame, frames[j+1], amount)
+	}
+	return overlapped
+}
+
+//SameImage reports whether every channel of every pixel of a is within tolerance of b.
+//Images of different sizes are never the same
+func SameImage(tolerance int, a, b image.Image) bool {
+	if !a.Bounds().Eq(b.Bounds()) {
+		return false
+	}
+	pa, pb := imaging.Clone(a).Pix, imaging.Clone(b).Pix
+	for i := range pa {
+		d := int(pa[i]) - int(pb[i])
+		if d > tolerance || d < -tolerance {
+			return false
+		}
+	}
+	return true
+}
+
+//StillEnds returns the range of frames from first up to last which is left once runs of
+//frames the same as the frame after them are trimmed from the start & runs the same as the
+//frame before them from the end. The frames where the motion starts & stops are kept
+func StillEnds(tolerance int, frames []image.Image) (first, last int) {
+	first, last = 0, len(frames)
+	for first+1 < last && SameImage(tolerance, frames[first], frames[first+1]) {
+		first++
+	}
+	for last-1 > first && SameImage(tolerance, frames[last-1], frames[last-2]) {
+		last--
+	}
+	return first, last
+}
+
+//ChangedFraction returns the fraction (0-1) of pixels whose colour differs between two
+//consecutive paletted frames. Frames of different sizes are counted as entirely changed
+func ChangedFraction(a, b *image.Paletted) float64 {
+	if !a.Bounds().Eq(b.Bounds()) {
+		return 1
+	}
+	r := a.Bounds()
+	if r.Empty() {
+		return 0
+	}
+	changed := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if a.At(x, y) != b.At(x, y) {
+				changed++
+			}
+		}
+	}
+	return float64(changed) / float64(r.Dx()*r.Dy())
+}
+
+//levelsRange returns the darkest & brightest colour channel values used in img ignoring
+//fully transparent pixels
+func levelsRange(img image.Image) (lo, hi uint8) {
+	src := imaging.Clone(img)
+	lo, hi = 255, 0
+	for i := 0; i < len(src.Pix); i += 4 {
+		if src.Pix[i+3] == 0 {
+			continue
+		}
+		for _, v := range src.Pix[i : i+3] {
+			if v < lo {
+				lo = v
+			}
+			if v > hi {
+				hi = v
+			}
+		}
+	}
+	return lo, hi
+}
+
+//StretchLevels maps colour channel values so lo becomes black & hi becomes white. The same
+//stretch is applied to the red, green & blue channels so colours don't shift
+func StretchLevels(lo, hi uint8, img image.Image) image.Image {
+	if hi <= lo || (lo == 0 && hi == 255) {
+		return img
+	}
+	var lut [256]uint8
+	for i := range lut {
+		v := (i - int(lo)) * 255 / (int(hi) - int(lo))
+		if v < 0 {
+			v = 0
+		} else if v > 255 {
+			v = 255
+		}
+		lut[i] = uint8(v)
+	}
+	return ConvertImage(&lut, img)
+}
+
+//AutoLevels stretches the contrast of the frames to the full range. In "frame" mode each
+//frame is stretched by its own darkest & brightest values while in "global" mode one
+//stretch computed over all frames is used so brightness doesn't flicker between frames
+func AutoLevels(mode string, frames []image.Image, verbose bool) []image.Image {
+	stretched := make([]image.Image, len(frames))
+	switch mode {
+	case "frame":
+		for j, frame := range frames {
+			lo, hi := levelsRange(frame)
+			if verbose {
+				log.Printf("Stretching levels of frame %d from %d-%d", j, lo, hi)
+			}
+			stretched[j] = StretchLevels(lo, hi, frame)
+		}
+	case "global":
+		lo, hi := uint8(255), uint8(0)
+		for _, frame := range frames {
+			flo, fhi := levelsRange(frame)
+			if flo < lo {
+				lo = flo
+			}
+			if fhi > hi {
+				hi = fhi
+			}
+		}
+		if verbose {
+			log.Printf("Stretching levels of all frames from %d-%d", lo, hi)
+		}
+		for j, frame := range frames {
+			stretched[j] = StretchLevels(lo, hi, frame)
+		}
+	default:
+		return frames
+	}
+	return stretched
+}
+
+//channelSums returns the sums of the red, green & blue values of img ignoring fully
+//transparent pixels
+func channelSums(img image.Image) (sums [3]float64) {
+	src := imaging.Clone(img)
+	for i := 0; i < len(src.Pix); i += 4 {
+		if src.Pix[i+3] == 0 {
+			continue
+		}
+		for c := 0; c < 3; c++ {
+			sums[c] += float64(src.Pix[i+c])
+		}
+	}
+	return sums
+}
+
+//grayWorldGains returns the gains for the red, green & blue channels which make their
+//averages equal. Channels with nothing in them are left alone
+func grayWorldGains(sums [3]float64) (gains [3]float64) {
+	gray := (sums[0] + sums[1] + sums[2]) / 3
+	for c := range gains {
+		gains[c] = 1
+		if sums[c] > 0 {
+			gains[c] = gray / sums[c]
+		}
+	}
+	return gains
+}
+
+//BalanceImage multiplies the red, green & blue channels of img by gains clipping to white
+func BalanceImage(gains [3]float64, img image.Image) image.Image {
+	var luts [3][256]uint8
+	for c := range luts {
+		for i := range luts[c] {
+			luts[c][i] = uint8(math.Min(255, float64(i)*gains[c]+0.5))
+		}
+	}
+	dst := imaging.Clone(img)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		for c := 0; c < 3; c++ {
+			dst.Pix[i+c] = luts[c][dst.Pix[i+c]]
+		}
+	}
+	return dst
+}
+
+//AutoWhiteBalance removes colour casts with a gray world correction which scales the red,
+//green & blue channels so their averages are equal. In "frame" mode each frame is corrected
+//by its own averages while in "global" mode one correction computed over all frames is used
+//so colours don't shift between frames
+func AutoWhiteBalance(mode string, frames []image.Image, verbose bool) []image.Image {
+	balanced := make([]image.Image, len(frames))
+	switch mode {
+	case "frame":
+		for j, frame := range frames {
+			sums := channelSums(frame)
+			gains := grayWorldGains(sums)
+			if verbose {
+				log.Printf("White balancing frame %d with gains %.2f, %.2f, %.2f", j, gains[0], gains[1], gains[2])
+			}
+			balanced[j] = BalanceImage(gains, frame)
+		}
+	case "global":
+		var sums [3]float64
+		for _, frame := range frames {
+			fsums := channelSums(frame)
+			for c := range sums {
+				sums[c] += fsums[c]
+			}
+		}
+		gains := grayWorldGains(sums)
+		if verbose {
+			log.Printf("White balancing all frames with gains %.2f, %.2f, %.2f", gains[0], gains[1], gains[2])
+		}
+		for j, frame := range frames {
+			balanced[j] = BalanceImage(gains, frame)
+		}
+	default:
+		return frames
+	}
+	return balanced
+}
+
+//Accumulate composites each frame with all the frames before it so bright moving things
+//leave trails like a long exposure. In "max" mode each colour channel keeps its brightest
+//value so far, in "lighten" mode each pixel keeps whichever is brighter overall & in "add"
+//mode values are summed & clipped to white. A frame of a different size to the one before
+//it restarts the accumulation
+func Accumulate(mode string, frames []image.Image, verbose bool) []image.Image {
+	if verbose {
+		log.Printf("Accumulating %d frames in %s mode", len(frames), mode)
+	}
+	accumulated := make([]image.Image, len(frames))
+	var acc *image.NRGBA
+	for j, frame := range frames {
+		if acc == nil || !acc.Bounds().Eq(frame.Bounds()) {
+			acc = imaging.Clone(frame)
+			accumulated[j] = acc
+			continue
+		}
+		next := imaging.Clone(frame)
+		for i := 0; i < len(next.Pix); i += 4 {
+			p, q := acc.Pix[i:i+4], next.Pix[i:i+4]
+			switch mode {
+			case "max":
+				for c := 0; c < 4; c++ {
+					if p[c] > q[c] {
+						q[c] = p[c]
+					}
+				}
+			case "lighten":
+				if 299*int(p[0])+587*int(p[1])+114*int(p[2]) > 299*int(q[0])+587*int(q[1])+114*int(q[2]) {
+					copy(q, p)
+				}
+			case "add":
+				for c := 0; c < 3; c++ {
+					if v := int(p[c]) + int(q[c]); v < 255 {
+						q[c] = uint8(v)
+					} else {
+						q[c] = 255
+					}
+				}
+				if p[3] > q[3] {
+					q[3] = p[3]
+				}
+			}
+		}
+		acc = next
+		accumulated[j] = acc
+	}
+	return accumulated
+}
@@ -0,0 +1,98 @@
+/*
+   Copyright 2014 Hariharan Srinath
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+//WriteSpriteSheet lays frames out in a grid of cols columns, filling each row in turn, &
+//saves it as the png or jpg image filename. A cols of 0 puts all the frames in a single row.
+//A CSS file named like filename with a .css extension is written alongside with a keyframe
+//animation stepping through the frames with their delays. A loopcount of -1 plays the
+//animation once as for EncodeGIF. Every cell is the size of the largest frame with smaller
+//frames centered in their cells so the animation steps by the same amount throughout
+func WriteSpriteSheet(filename string, frames []*image.Paletted, delays []int, cols, loopcount int) error {
+	if len(frames) == 0 {
+		return fmt.Errorf("no frames to write")
+	}
+	if cols <= 0 || cols > len(frames) {
+		cols = len(frames)
+	}
+	rows := (len(frames) + cols - 1) / cols
+	w, h := 0, 0
+	for _, frame := range frames {
+		b := frame.Bounds()
+		if b.Dx() > w {
+			w = b.Dx()
+		}
+		if b.Dy() > h {
+			h = b.Dy()
+		}
+	}
+
+	sheet := imaging.New(w*cols, h*rows, color.NRGBA{})
+	for j, frame := range frames {
+		b := frame.Bounds()
+		sheet = imaging.Paste(sheet, frame, image.Pt(w*(j%cols)+(w-b.Dx())/2, h*(j/cols)+(h-b.Dy())/2))
+	}
+	if err := imaging.Save(sheet, filename); err != nil {
+		return err
+	}
+
+	total := 0
+	for _, d := range delays {
+		total += d
+	}
+	iterations := "infinite"
+	if loopcount < 0 {
+		iterations = "1"
+	}
+
+	var css bytes.Buffer
+	fmt.Fprintf(&css, "/* %d frames in %dx%d cells in %d columns & %d rows of %s */\n", len(frames), w, h, cols, rows, filepath.Base(filename))
+	fmt.Fprintf(&css, ".sprite {\n")
+	fmt.Fprintf(&css, "\twidth: %dpx;\n\theight: %dpx;\n", w, h)
+	fmt.Fprintf(&css, "\tbackground: url(%q) no-repeat;\n", filepath.Base(filename))
+	fmt.Fprintf(&css, "\tanimation: sprite %.2fs step-end %s forwards;\n", float64(total)/100, iterations)
+	fmt.Fprintf(&css, "}\n\n@keyframes sprite {\n")
+	//Each keyframe holds its frame until the next keyframe starts with step-end timing
+	elapsed := 0
+	for j := range frames {
+		percent := 0.0
+		if total > 0 {
+			percent = float64(elapsed) * 100 / float64(total)
+		}
+		fmt.Fprintf(&css, "\t%.3f%% { background-position: %dpx %dpx; }\n", percent, -w*(j%cols), -h*(j/cols))
+		if j < len(delays) {
+			elapsed += delays[j]
+		}
+	}
+	last := len(frames) - 1
+	fmt.Fprintf(&css, "\t100%% { background-position: %dpx %dpx; }\n}\n", -w*(last%cols), -h*(last/cols))
+
+	cssname := strings.TrimSuffix(filename, filepath.Ext(filename)) + ".css"
+	return ioutil.WriteFile(cssname, css.Bytes(), 0644)
+}
@@ -0,0 +1,100 @@
+/*
+   Copyright 2014 Hariharan Srinath
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+//textFace is the fixed width bitmap font used for text annotations
+var textFace = basicfont.Face7x13
+
+//RenderText draws one or more lines of white text with a black outline onto a transparent
+//image just big enough to hold it. The text is drawn at the font's native size
+func RenderText(text string) *image.NRGBA {
+	lines := strings.Split(text, "\n")
+	widest := 0
+	for _, line := range lines {
+		if w := font.MeasureString(textFace, line).Ceil(); w > widest {
+			widest = w
+		}
+	}
+	lineheight := textFace.Metrics().Height.Ceil()
+
+	//A 1 pixel margin on each side leaves room for the outline
+	dst := image.NewNRGBA(image.Rect(0, 0, widest+2, lineheight*len(lines)+2))
+	drawer := &font.Drawer{Dst: dst, Face: textFace}
+	draw := func(c color.Color, dx, dy int) {
+		drawer.Src = image.NewUniform(c)
+		for j, line := range lines {
+			drawer.Dot = fixed.P(1+dx, 1+dy+j*lineheight+textFace.Metrics().Ascent.Ceil())
+			drawer.DrawString(line)
+		}
+	}
+	for _, d := range [][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+		draw(color.Black, d[0], d[1])
+	}
+	draw(color.White, 0, 0)
+	return dst
+}
+
+//AnnotateImage draws text in the bottom left corner of img. The text is enlarged for big
+//images so it stays readable at roughly a twentieth of the image height per line
+func AnnotateImage(text string, img image.Image, verbose bool) image.Image {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return img
+	}
+	label := RenderText(text)
+	b := img.Bounds()
+	lines := strings.Count(text, "\n") + 1
+	if zoom := b.Dy() / (20 * textFace.Metrics().Height.Ceil()); zoom > 1 {
+		label = imaging.Resize(label, label.Bounds().Dx()*zoom, label.Bounds().Dy()*zoom, imaging.NearestNeighbor)
+	}
+	margin := b.Dy() / 50
+	pos := image.Pt(margin, b.Dy()-label.Bounds().Dy()-margin)
+	if verbose {
+		log.Printf("Annotating image with %d lines of text", lines)
+	}
+	return imaging.Overlay(img, label, pos, 1.0)
+}
+
+//SidecarText returns the contents of the text file sitting alongside an image file with
+//the same name but a .txt extension. Missing sidecars give an empty string. Windows line
+//endings are turned into plain newlines so no stray carriage returns are drawn
+func SidecarText(filename string) (string, error) {
+	sidecar := strings.TrimSuffix(filename, filepath.Ext(filename)) + ".txt"
+	data, err := ioutil.ReadFile(sidecar)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.Replace(string(data), "\r\n", "\n", -1), nil
+}
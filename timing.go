@@ -0,0 +1,283 @@
+/*
+   Copyright 2014 Hariharan Srinath
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//subtitleTime formats a time in hundredths of a second as hh:mm:ss followed by sep and
+//milliseconds, the format used by SRT (sep ",") & WebVTT (sep ".") files
+func subtitleTime(hundredths int, sep string) string {
+	ms := hundredths * 10
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+//WriteTiming writes a subtitle file with one cue per frame showing the frame number & the
+//source image it was made from, timed by the frame delays. Files ending in .vtt are written
+//as WebVTT & anything else as SRT
+func WriteTiming(filename string, delays []int, sources []string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+
+	vtt := strings.EqualFold(filepath.Ext(filename), ".vtt")
+	sep := ","
+	if vtt {
+		sep = "."
+		fmt.Fprintf(w, "WEBVTT\n\n")
+	}
+
+	start := 0
+	for j, delay := range delays {
+		if !vtt {
+			fmt.Fprintf(w, "%d\n", j+1)
+		}
+		fmt.Fprintf(w, "%s --> %s\n", subtitleTime(start, sep), subtitleTime(start+delay, sep))
+		fmt.Fprintf(w, "frame %d : %s\n\n", j, sources[j])
+		start += delay
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//timestampPattern matches a capture time in a file name like frame_001523ms.jpg or
+//shot-12.5s.png. The last match in the name is used
+var timestampPattern = regexp.MustCompile(`(\d+(?:\.\d+)?)(ms|s)`)
+
+//FilenameTimestamp returns the capture time in milliseconds written in a file name
+func FilenameTimestamp(filename string) (float64, error) {
+	base := filepath.Base(filename)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	matches := timestampPattern.FindAllStringSubmatch(base, -1)
+	if matches == nil {
+		return 0, fmt.Errorf("no timestamp like 1523ms or 1.5s in %s", filename)
+	}
+	m := matches[len(matches)-1]
+	t, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, err
+	}
+	if m[2] == "s" {
+		t *= 1000
+	}
+	return t, nil
+}
+
+//TimestampDelays returns delays in hundredths of a second reproducing the gaps between the
+//capture times in the file names of consecutive images. The last image is held for the same
+//time as the gap before it. It is an error if a name has no timestamp or the timestamps
+//don't increase
+func TimestampDelays(filenames []string) ([]int, error) {
+	times := make([]float64, len(filenames))
+	for j, filename := range filenames {
+		var err error
+		if times[j], err = FilenameTimestamp(filename); err != nil {
+			return nil, err
+		}
+	}
+	return GapDelays(times, filenames)
+}
+
+//GapDelays returns delays in hundredths of a second reproducing the gaps between the times
+//in milliseconds of consecutive images named by filenames. Each time is rounded before the
+//gaps are taken so rounding doesn't add up over long or variable frame rate sequences. The
+//last image is held for the same time as the gap before it. It is an error if the times
+//don't increase
+func GapDelays(times []float64, filenames []string) ([]int, error) {
+	if len(times) < 2 {
+		return nil, fmt.Errorf("need at least 2 images to find the gaps between them")
+	}
+	for j := 1; j < len(times); j++ {
+		if times[j] <= times[j-1] {
+			return nil, fmt.Errorf("timestamp of %s is not after that of %s", filenames[j], filenames[j-1])
+		}
+	}
+	delays := make([]int, len(times))
+	for j := 0; j+1 < len(times); j++ {
+		delays[j] = int((times[j+1]-times[0])/10+0.5) - int((times[j]-times[0])/10+0.5)
+		if delays[j] < 1 {
+			delays[j] = 1
+		}
+	}
+	delays[len(delays)-1] = delays[len(delays)-2]
+	return delays, nil
+}
+
+//LoadTimestamps reads a timestamp file with the time of each frame in milliseconds on its
+//own line, such as ffmpeg writes with -f mkvtimestamp_v2. Blank lines & lines starting with
+//# are skipped
+func LoadTimestamps(filename string) ([]float64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var times []float64
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		t, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid timestamp %q", n, line)
+		}
+		times = append(times, t)
+	}
+	return times, scanner.Err()
+}
+
+//StageTimer adds up the time spent in each stage of processing across all frames & workers.
+//A nil StageTimer does nothing so timing can be left in place when it isn't wanted
+type StageTimer struct {
+	mu     sync.Mutex
+	totals map[string]time.Duration
+	stages []string
+	//images holds the time of each stage spent on each image by its index in the list of
+	//source images, which has an entry for each frame of an animated GIF & each tile
+	images      map[int]map[string]time.Duration
+	imagestages []string
+}
+
+//NewStageTimer returns an empty StageTimer
+func NewStageTimer() *StageTimer {
+	return &StageTimer{totals: map[string]time.Duration{}, images: map[int]map[string]time.Duration{}}
+}
+
+//Add adds the time since start to the total for stage
+func (t *StageTimer) Add(stage string, start time.Time) {
+	if t == nil {
+		return
+	}
+	d := time.Since(start)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.add(stage, d)
+}
+
+//AddImage adds the time since start to the total for stage & to the time spent on source
+//image number index in that stage
+func (t *StageTimer) AddImage(stage string, index int, start time.Time) {
+	if t == nil {
+		return
+	}
+	d := time.Since(start)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.add(stage, d)
+	if t.images[index] == nil {
+		t.images[index] = map[string]time.Duration{}
+	}
+	found := false
+	for _, s := range t.imagestages {
+		if s == stage {
+			found = true
+		}
+	}
+	if !found {
+		t.imagestages = append(t.imagestages, stage)
+	}
+	t.images[index][stage] += d
+}
+
+//add adds d to the total for stage. The caller must hold t.mu
+func (t *StageTimer) add(stage string, d time.Duration) {
+	if _, ok := t.totals[stage]; !ok {
+		t.stages = append(t.stages, stage)
+	}
+	t.totals[stage] += d
+}
+
+//WriteCSV writes the time in microseconds spent on each source image in each stage as a
+//CSV file with a row for each of filenames that was timed. frames & tiles give the frame of
+//an animated GIF & the -cropgrid tile each entry of filenames is, or are nil if there are none
+func (t *StageTimer) WriteCSV(filename string, filenames []string, frames, tiles []int) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write(append([]string{"index", "image", "frame", "tile"}, t.imagestages...))
+	for j, name := range filenames {
+		stages, ok := t.images[j]
+		if !ok {
+			continue
+		}
+		var frame, tile string
+		if frames != nil {
+			frame = fmt.Sprintf("%d", frames[j])
+		}
+		if tiles != nil {
+			tile = fmt.Sprintf("%d", tiles[j])
+		}
+		row := []string{fmt.Sprintf("%d", j), name, frame, tile}
+		for _, stage := range t.imagestages {
+			row = append(row, fmt.Sprintf("%d", stages[stage].Microseconds()))
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//Report logs the total time of each stage in the order the stages were first seen. With
+//several threads the totals are summed across workers so may add up to more than the run
+func (t *StageTimer) Report() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var all time.Duration
+	for _, stage := range t.stages {
+		all += t.totals[stage]
+	}
+	for _, stage := range t.stages {
+		pct := 0.0
+		if all > 0 {
+			pct = float64(t.totals[stage]) * 100 / float64(all)
+		}
+		log.Printf("Timing %-10s %12s %5.1f%%", stage, t.totals[stage].Round(time.Millisecond), pct)
+	}
+}